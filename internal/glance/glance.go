@@ -34,8 +34,9 @@ type application struct {
 
 	parsedManifest []byte
 
-	slugToPage map[string]*page
-	widgetByID map[uint64]widget
+	slugToPage    map[string]*page
+	widgetByID    map[uint64]widget
+	fileReloaders *fileReloaders
 
 	RequiresAuth           bool
 	authSecretKey          []byte
@@ -46,11 +47,12 @@ type application struct {
 
 func newApplication(c *config) (*application, error) {
 	app := &application{
-		Version:    buildVersion,
-		CreatedAt:  time.Now(),
-		Config:     *c,
-		slugToPage: make(map[string]*page),
-		widgetByID: make(map[uint64]widget),
+		Version:       buildVersion,
+		CreatedAt:     time.Now(),
+		Config:        *c,
+		slugToPage:    make(map[string]*page),
+		widgetByID:    make(map[uint64]widget),
+		fileReloaders: &fileReloaders{},
 	}
 	config := &app.Config
 
@@ -148,6 +150,8 @@ func newApplication(c *config) (*application, error) {
 
 	providers := &widgetProviders{
 		assetResolver: app.StaticAssetPath,
+		httpClient:    sharedHTTPClient,
+		fileReloaders: app.fileReloaders,
 	}
 
 	for p := range config.Pages {
@@ -269,6 +273,15 @@ func (p *page) updateOutdatedWidgets() {
 	wg.Wait()
 }
 
+// reloadWidgetFiles re-reads the files used by widgets, such as prompt files,
+// without recreating the application. Widgets keep their previous contents if
+// reading fails.
+func (a *application) reloadWidgetFiles() {
+	for _, err := range a.fileReloaders.reload() {
+		log.Printf("Failed to reload widget files: %v", err)
+	}
+}
+
 func (a *application) resolveUserDefinedAssetPath(path string) string {
 	if strings.HasPrefix(path, "/assets/") {
 		return a.Config.Server.BaseURL + path
@@ -404,26 +417,28 @@ func (a *application) handleNotFound(w http.ResponseWriter, _ *http.Request) {
 }
 
 func (a *application) handleWidgetRequest(w http.ResponseWriter, r *http.Request) {
-	// TODO: this requires a rework of the widget update logic so that rather
-	// than locking the entire page we lock individual widgets
-	w.WriteHeader(http.StatusNotImplemented)
-
-	// widgetValue := r.PathValue("widget")
+	widgetValue := r.PathValue("widget")
 
-	// widgetID, err := strconv.ParseUint(widgetValue, 10, 64)
-	// if err != nil {
-	// 	a.handleNotFound(w, r)
-	// 	return
-	// }
+	widgetID, err := strconv.ParseUint(widgetValue, 10, 64)
+	if err != nil {
+		a.handleNotFound(w, r)
+		return
+	}
 
-	// widget, exists := a.widgetByID[widgetID]
+	widget, exists := a.widgetByID[widgetID]
+	if !exists {
+		a.handleNotFound(w, r)
+		return
+	}
 
-	// if !exists {
-	// 	a.handleNotFound(w, r)
-	// 	return
-	// }
+	if a.handleUnauthorizedResponse(w, r, showUnauthorizedJSON) {
+		return
+	}
 
-	// widget.handleRequest(w, r)
+	// The page lock is not held here since handling a request can take as long
+	// as a full update of the widget, widgets that handle requests guard their
+	// own state against concurrent renders and updates instead
+	widget.handleRequest(w, r)
 }
 
 func (a *application) StaticAssetPath(asset string) string {
@@ -435,7 +450,8 @@ func (a *application) VersionedAssetPath(asset string) string {
 		"?v=" + strconv.FormatInt(a.CreatedAt.Unix(), 10)
 }
 
-func (a *application) server() (func() error, func() error) {
+// newServeMux registers the routes served by the application
+func (a *application) newServeMux() *http.ServeMux {
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("GET /{$}", a.handlePageRequest)
@@ -483,13 +499,22 @@ func (a *application) server() (func() error, func() error) {
 		w.Write(a.parsedManifest)
 	})
 
-	var absAssetsPath string
 	if a.Config.Server.AssetsPath != "" {
-		absAssetsPath, _ = filepath.Abs(a.Config.Server.AssetsPath)
 		assetsFS := fileServerWithCache(http.Dir(a.Config.Server.AssetsPath), 2*time.Hour)
 		mux.Handle("/assets/{path...}", http.StripPrefix("/assets/", assetsFS))
 	}
 
+	return mux
+}
+
+func (a *application) server() (func() error, func() error) {
+	mux := a.newServeMux()
+
+	var absAssetsPath string
+	if a.Config.Server.AssetsPath != "" {
+		absAssetsPath, _ = filepath.Abs(a.Config.Server.AssetsPath)
+	}
+
 	server := http.Server{
 		Addr:    fmt.Sprintf("%s:%d", a.Config.Server.Host, a.Config.Server.Port),
 		Handler: mux,
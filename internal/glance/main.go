@@ -6,6 +6,9 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
 
 	"golang.org/x/crypto/bcrypt"
 )
@@ -97,6 +100,21 @@ func serveApp(configPath string) error {
 	exitChannel := make(chan struct{})
 	hadValidConfigOnStartup := false
 	var stopServer func() error
+	var currentApp atomic.Pointer[application]
+
+	// SIGHUP re-reads files used by widgets without reloading the whole config
+	reloadSignals := make(chan os.Signal, 1)
+	signal.Notify(reloadSignals, syscall.SIGHUP)
+	defer signal.Stop(reloadSignals)
+
+	go func() {
+		for range reloadSignals {
+			if app := currentApp.Load(); app != nil {
+				log.Println("Received SIGHUP, reloading widget files...")
+				app.reloadWidgetFiles()
+			}
+		}
+	}()
 
 	onChange := func(newContents []byte) {
 		if stopServer != nil {
@@ -129,6 +147,8 @@ func serveApp(configPath string) error {
 			hadValidConfigOnStartup = true
 		}
 
+		currentApp.Store(app)
+
 		if stopServer != nil {
 			if err := stopServer(); err != nil {
 				log.Printf("Error while trying to stop server: %v", err)
@@ -170,6 +190,8 @@ func serveApp(configPath string) error {
 			return fmt.Errorf("creating application: %w", err)
 		}
 
+		currentApp.Store(app)
+
 		startServer, _ := app.server()
 		if err := startServer(); err != nil {
 			return fmt.Errorf("starting server: %w", err)
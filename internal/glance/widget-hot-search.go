@@ -0,0 +1,254 @@
+package glance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// 各热榜组件共用的配置和筛选逻辑，具体平台只需负责获取数据并转换为 hotSearchItem
+type hotSearchWidgetBase struct {
+	widgetBase `yaml:",inline"`
+
+	// 展示的条目数量（1-50），默认10条
+	ShowCount int `yaml:"show-count"`
+
+	// 已弃用：show-count 的别名，同时设置时优先使用 limit 以保持兼容
+	Limit int `yaml:"limit"`
+
+	RefreshInterval int    `yaml:"refresh-interval"`
+	Category        string `yaml:"category"`
+
+	// 需要隐藏的关键词，忽略大小写按子串匹配，以 regexp: 开头的条目按正则表达式匹配
+	Blocklist []string `yaml:"blocklist"`
+
+	// 请求平台接口的超时时间，避免连接挂起时阻塞更新
+	Timeout durationField `yaml:"timeout"`
+
+	blocklist          hotSearchBlocklist
+	includedCategories map[string]struct{}
+	stats              widgetStats
+
+	// 接口持续失败时推迟之后的更新
+	breaker circuitBreaker
+}
+
+// 最短的刷新间隔（分钟），避免过于频繁地请求平台接口
+const minHotSearchRefreshInterval = 5
+
+// 默认的请求超时时间
+const defaultHotSearchTimeout = 15 * time.Second
+
+// 默认和最多展示的条目数量
+const (
+	defaultHotSearchShowCount = 10
+	maxHotSearchShowCount     = 50
+)
+
+// 设置标题、展示数量和刷新间隔的默认值，并编译屏蔽列表
+func (base *hotSearchWidgetBase) initializeHotSearch(title string, logger *slog.Logger) error {
+	base.withTitle(title)
+
+	// 优先使用limit字段，如果未设置则使用show-count，之后只使用 ShowCount
+	if base.Limit > 0 {
+		if base.ShowCount > 0 && base.ShowCount != base.Limit {
+			logger.Warn("Both limit and show-count are set, using limit", "limit", base.Limit, "show_count", base.ShowCount)
+		}
+		base.ShowCount = base.Limit
+	}
+
+	if base.ShowCount <= 0 {
+		base.ShowCount = defaultHotSearchShowCount
+	} else if base.ShowCount > maxHotSearchShowCount {
+		logger.Warn("show-count is too large, using the maximum instead", "show_count", base.ShowCount, "maximum", maxHotSearchShowCount)
+		base.ShowCount = maxHotSearchShowCount
+	}
+
+	if base.RefreshInterval < 0 {
+		return fmt.Errorf("refresh-interval must not be negative")
+	}
+
+	if base.RefreshInterval == 0 {
+		base.RefreshInterval = 30 // 默认30分钟
+	} else if base.RefreshInterval < minHotSearchRefreshInterval {
+		logger.Warn("refresh-interval is too short, using the minimum instead", "refresh_interval", base.RefreshInterval, "minimum", minHotSearchRefreshInterval)
+		base.RefreshInterval = minHotSearchRefreshInterval
+	}
+
+	// cache 会覆盖 refresh-interval，同样不能低于最短间隔
+	minCacheDuration := durationField(minHotSearchRefreshInterval * time.Minute)
+	if base.CustomCacheDuration < 0 {
+		return fmt.Errorf("cache must not be negative")
+	} else if base.CustomCacheDuration > 0 && base.CustomCacheDuration < minCacheDuration {
+		logger.Warn("cache is too short, using the minimum instead", "cache", time.Duration(base.CustomCacheDuration), "minimum", time.Duration(minCacheDuration))
+		base.CustomCacheDuration = minCacheDuration
+	}
+
+	base.withCacheDuration(time.Duration(base.RefreshInterval) * time.Minute)
+
+	if base.Timeout <= 0 {
+		base.Timeout = durationField(defaultHotSearchTimeout)
+	}
+
+	base.blocklist = compileHotSearchBlocklist(base.Blocklist, logger)
+
+	base.includedCategories = make(map[string]struct{})
+	base.includeCategories([]string{base.Category})
+
+	return nil
+}
+
+// 处理一次更新的结果，返回是否可以使用本次获取的数据
+// 失败时由熔断器决定下一次更新的时间，部分来源失败时展示获取成功的内容并给出提示，不计入连续失败次数
+func (base *hotSearchWidgetBase) handleUpdateResult(err error, logger *slog.Logger, msg string) bool {
+	if err != nil && !errors.Is(err, errPartialContent) {
+		base.withNotice(nil)
+		base.breaker.handleFailure(&base.widgetBase, logger, msg, err)
+		return false
+	}
+
+	// 成功后清零连续失败次数，恢复正常的刷新间隔
+	base.breaker.recordSuccess()
+	return base.canContinueUpdateAfterHandlingErr(err)
+}
+
+// 只展示属于这些类别的条目，全称和简称都可以使用
+func (base *hotSearchWidgetBase) includeCategories(categories []string) {
+	for _, category := range categories {
+		if key := hotSearchCategoryKey(category); key != "" {
+			base.includedCategories[key] = struct{}{}
+		}
+	}
+}
+
+// 比较类别时使用的形式，类别的全称和简称（如娱乐和娱）视为相同
+func hotSearchCategoryKey(category string) string {
+	return weiboCategoryShortName(strings.TrimSpace(category))
+}
+
+// 可以按标题和类别筛选的条目，各平台的条目结构通过它共用同一套筛选逻辑
+type hotSearchFilterable interface {
+	// 用于判断条目是否为空以及匹配屏蔽列表的标题
+	filterTitle() string
+
+	// 条目的类别，hasCategories 为 false 表示来源平台没有类别，此时不按类别筛选
+	filterCategory() (category string, hasCategories bool)
+}
+
+// 过滤掉标题为空、不属于 category 或命中屏蔽列表的条目
+func applyHotSearchFilters[T hotSearchFilterable](base *hotSearchWidgetBase, items []T) []T {
+	filtered := make([]T, 0, len(items))
+	for _, item := range items {
+		title := item.filterTitle()
+		if title == "" || base.blocklist.matches(title) {
+			continue
+		}
+		if category, hasCategories := item.filterCategory(); hasCategories && len(base.includedCategories) > 0 {
+			if _, included := base.includedCategories[hotSearchCategoryKey(category)]; !included {
+				continue
+			}
+		}
+		filtered = append(filtered, item)
+	}
+
+	return filtered
+}
+
+// 返回请求平台接口的统计数据
+func (base *hotSearchWidgetBase) Stats() widgetStatsSnapshot {
+	return base.stats.snapshot()
+}
+
+// 最多保留 show-count 条
+func truncateHotSearches[T any](base *hotSearchWidgetBase, items []T) []T {
+	if base.ShowCount > 0 && len(items) > base.ShowCount {
+		return items[:base.ShowCount]
+	}
+
+	return items
+}
+
+// 各热榜组件共用的条目结构
+type hotSearchItem struct {
+	// 在平台榜单中的排名（从1开始）
+	Rank     int
+	Title    string
+	Category string
+	// 条目所属的平台，如 weibo、zhihu
+	Source string
+	// 用于排序和筛选的热度数值
+	Heat int64
+	// 平台返回的热度文本，如“1234 万热度”
+	HeatText string
+	URL      template.URL
+
+	// 来源平台没有类别（如知乎），这类条目不按 category 筛选
+	noCategories bool
+}
+
+func (item hotSearchItem) filterTitle() string {
+	return item.Title
+}
+
+func (item hotSearchItem) filterCategory() (string, bool) {
+	return item.Category, !item.noCategories
+}
+
+// 可以被聚合到 trending 组件中的热榜来源
+type hotSearchSource interface {
+	fetchHotSearchItems(ctx context.Context) ([]hotSearchItem, error)
+	setProviders(providers *widgetProviders)
+}
+
+// 编译后的关键词屏蔽列表
+type hotSearchBlocklist struct {
+	substrings []string
+	patterns   []*regexp.Regexp
+}
+
+// 编译屏蔽列表，以 regexp: 开头的条目按正则表达式匹配
+// 正则表达式无效时记录错误并退回按子串匹配
+func compileHotSearchBlocklist(entries []string, logger *slog.Logger) hotSearchBlocklist {
+	var blocklist hotSearchBlocklist
+
+	for _, entry := range entries {
+		if pattern, isRegexp := strings.CutPrefix(entry, "regexp:"); isRegexp {
+			compiled, err := regexp.Compile(pattern)
+			if err == nil {
+				blocklist.patterns = append(blocklist.patterns, compiled)
+				continue
+			}
+
+			logger.Error("Invalid blocklist regexp, falling back to substring matching", "pattern", pattern, "error", err)
+			entry = pattern
+		}
+
+		if entry = strings.TrimSpace(entry); entry != "" {
+			blocklist.substrings = append(blocklist.substrings, strings.ToLower(entry))
+		}
+	}
+
+	return blocklist
+}
+
+func (blocklist *hotSearchBlocklist) matches(word string) bool {
+	lowered := strings.ToLower(word)
+	for _, substring := range blocklist.substrings {
+		if strings.Contains(lowered, substring) {
+			return true
+		}
+	}
+
+	for _, pattern := range blocklist.patterns {
+		if pattern.MatchString(word) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -1,179 +1,149 @@
 package glance
 
 import (
+	"bufio"
 	"bytes"
+	"cmp"
 	"context"
+	cryptorand "crypto/rand"
+	"crypto/tls"
+	_ "embed"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"html"
 	"html/template"
+	"io"
+	"log/slog"
+	"maps"
+	"math/rand/v2"
 	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	texttemplate "text/template"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 const (
 	defaultFactCacheDuration = 2 * time.Hour
-	factAPIURL              = "https://uselessfacts.jsph.pl/api/v2/facts/random"
-	aiAPIURL                = "https://api.siliconflow.cn/v1/chat/completions"
-)
+	minFactCacheDuration     = time.Minute
+	factAPIURL               = "https://uselessfacts.jsph.pl/api/v2/facts/random"
+	aiAPIURL                 = "https://api.siliconflow.cn/v1/chat/completions"
 
-var randomFactWidgetTemplate = mustParseTemplate("random-fact.html", "widget-base.html")
+	// 去重历史记录的长度以及遇到重复事实时的最大获取次数
+	defaultFactHistorySize = 10
+	maxFactFetchAttempts   = 3
+	maxFactCount           = 10
 
-// RandomFactWidget 配置结构体
-type randomFactWidget struct {
-	widgetBase `yaml:",inline"`
-	
-	// API配置
-	APIKey      string `yaml:"apikey"`
-	Model       string `yaml:"model"`
-	APIURL      string `yaml:"apiurl"`
-	
-	// 内部状态
-	client      *http.Client
-	CachedData  *randomFactData
-	lastUpdate  time.Time
-}
+	defaultFactRetries          = 2
+	defaultAIRetries            = 1
+	defaultAIConcurrency        = 3
+	defaultTranslationCacheSize = 50
+	defaultFactFetchTimeout     = 10 * time.Second
+	defaultAITimeout            = 60 * time.Second
 
-// 随机事实数据结构
-type randomFactData struct {
-	FactID   string `json:"fact_id"`
-	FactText string `json:"fact_text"`
-	Content  string `json:"content"`
-	Source   string `json:"source"`
-}
+	// AI请求的token上限，输出被截断时重试使用的上限
+	aiMaxTokens          = 512
+	aiRetryMaxTokens     = 1024
+	aiFinishReasonLength = "length"
 
-// 原始事实API响应
-type rawFactResponse struct {
-	ID   string `json:"id"`
-	Text string `json:"text"`
-	Source string `json:"source,omitempty"`
-	SourceURL string `json:"source_url,omitempty"`
-	Language string `json:"language,omitempty"`
-	Permalink string `json:"permalink,omitempty"`
-}
+	// 默认读取的AI输出内容的路径
+	defaultAIResponsePath = "choices.0.message.content"
 
-// AI API响应
-type aiResponse struct {
-	Choices []struct {
-		Message struct {
-			Content string `json:"content"`
-		} `json:"message"`
-	} `json:"choices"`
-	Error *struct {
-		Message string `json:"message"`
-		Type    string `json:"type"`
-		Code    string `json:"code"`
-	} `json:"error"`
-}
+	// 更新被取消、保留部分流式输出时使用的内部结束原因
+	aiFinishReasonCancelled = "cancelled"
 
-// 初始化随机事实Widget
-func (widget *randomFactWidget) initialize() error {
-	widget.withTitle("Random Fact").withCacheDuration(time.Duration(widget.CustomCacheDuration))
+	// 当天调用次数达到额度的这一比例后改用 cheap-model
+	cheapModelBudgetThreshold = 0.8
 
-	// 设置默认缓存时间
-	if widget.CustomCacheDuration == 0 {
-		widget.CustomCacheDuration = durationField(defaultFactCacheDuration)
-		widget.withCacheDuration(defaultFactCacheDuration)
-	}
-	
-	// 检查是否配置了AI API参数
-	hasAIConfig := widget.APIKey != "" && widget.Model != "" && widget.APIURL != ""
-	
-	if !hasAIConfig {
-		fmt.Printf("AI API not configured, will use raw facts only\n")
-	}
-	
-	// 初始化HTTP客户端
-	widget.client = &http.Client{
-		Timeout: 30 * time.Second,
-	}
-	
-	return nil
-}
+	// 默认提示词要求模型在无法处理输入时返回的内容
+	aiRefusalSentinel = "无法处理该输入"
 
-// 更新数据
-func (widget *randomFactWidget) update(ctx context.Context) {
-	// 检查缓存是否有效
-	cacheDuration := time.Duration(widget.CustomCacheDuration)
-	if widget.CachedData != nil && time.Since(widget.lastUpdate) < cacheDuration {
-		return
-	}
-	
-	// 获取原始事实数据
-	rawFact, err := widget.fetchRawFact()
-	if err != nil {
-		fmt.Printf("Error fetching raw fact: %v\n", err)
-		widget.withError(err).scheduleEarlyUpdate()
-		return
-	}
-	
-	// 检查是否配置了AI API参数
-	hasAIConfig := widget.APIKey != "" && widget.Model != "" && widget.APIURL != ""
-	
-	var processedContent string
-	var source string
-	
-	if hasAIConfig {
-		// 获取AI处理后的内容
-		processedContent, err = widget.processWithAI(rawFact.Text)
-		if err != nil {
-			// 如果AI处理失败，使用原始文本
-			processedContent = rawFact.Text
-		}
-		source = widget.extractModelName()
-	} else {
-		// 没有配置AI API，使用原始文本
-		processedContent = rawFact.Text
-		source = "uselessfacts.jsph.pl"
-	}
-	
-	// 更新缓存数据
-	widget.CachedData = &randomFactData{
-		FactID:   rawFact.ID,
-		FactText: rawFact.Text,
-		Content:  processedContent,
-		Source:   source,
-	}
-	widget.lastUpdate = time.Now()
-	widget.scheduleNextUpdate()
-}
+	// 默认提示词对每行字数的要求
+	aiOutputMinLineLength = 8
+	aiOutputMaxLineLength = 60
+)
 
-// 获取原始事实数据
-func (widget *randomFactWidget) fetchRawFact() (*rawFactResponse, error) {
-	req, err := http.NewRequest("GET", factAPIURL, nil)
-	if err != nil {
-		return nil, err
-	}
-	
-	resp, err := widget.client.Do(req)
+const (
+	factSourceRemote        = "remote"
+	factSourceLocal         = "local"
+	factSourceLocalFallback = "local-fallback"
+
+	// 内置事实列表的来源名称
+	factSourceBuiltIn = "built-in"
+)
+
+// 内置的事实列表，未配置 facts-file 时用于 local 模式以及事实API请求失败时
+//
+//go:embed random-facts.txt
+var builtInFactsFile []byte
+
+var builtInFacts = func() []rawFactResponse {
+	facts, err := parseLocalFacts("built-in facts", builtInFactsFile)
 	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API returned status code %d", resp.StatusCode)
+		panic(err)
 	}
-	
-	var fact rawFactResponse
-	if err := json.NewDecoder(resp.Body).Decode(&fact); err != nil {
-		return nil, err
+
+	for i := range facts {
+		facts[i].origin = factSourceBuiltIn
 	}
-	
-	return &fact, nil
+
+	return facts
+}()
+
+const (
+	factDedupeByID        = "id"
+	factDedupeByText      = "text"
+	factDedupeByIDAndText = "both"
+)
+
+// 校对翻译时使用的系统提示词
+const aiSelfCheckPrompt = "你是翻译校对助手。用户会给出一条英文原文和对应的中文输出，中文输出第一行为翻译，第二行为补充说明。请检查翻译是否忠实地表达了原文的意思：如果准确，原样返回中文输出；如果不准确，返回修正后的版本。保持两行的格式，不要输出任何其他内容。"
+
+// 默认的用户提示词，直接发送事实原文
+const defaultFactUserPrompt = "{{ .Text }}"
+
+// 输出长度不符合要求时重试使用的附加指令
+const aiLengthRetryInstruction = "严格遵守输出格式：只输出两行，第一行为翻译，第二行为补充说明，每行不少于8个字且不超过60个字。"
+
+// 要求模型以 {漢字|かな} 的格式为日语输出中的汉字标注读音
+const aiRubyInstruction = "输出为日语时，为其中的每个汉字词标注平假名读音，格式为 {漢字|かんじ}，不要使用其他标注方式。"
+
+// AI输出中的读音标注，如 {日本|にほん}
+var rubyAnnotationPattern = regexp.MustCompile(`\{([^{}|\n]+)\|([^{}|\n]+)\}`)
+
+// prompt-language 使用的简短系统提示词模板
+const factLanguagePromptTemplate = "Translate this fact into %s and add one explanatory sentence. Output exactly two lines: the translation on the first line and the explanation on the second, without any labels or other text."
+
+// prompt-language 支持的语言预设
+var factPromptLanguages = map[string]string{
+	"zh":    "Simplified Chinese",
+	"zh-TW": "Traditional Chinese",
+	"ja":    "Japanese",
+	"ko":    "Korean",
+	"fr":    "French",
+	"es":    "Spanish",
+	"de":    "German",
 }
 
-// 使用AI处理事实内容
-func (widget *randomFactWidget) processWithAI(text string) (string, error) {
-	if widget.APIKey == "" {
-		return "", fmt.Errorf("API key not configured")
-	}
-	
-	payload := map[string]interface{}{
-		"model": widget.Model,
-		"messages": []map[string]string{
-			{
-				"role": "system",
-				"content": `# Role: Random Fact 理解助手
+// 事实API支持的语言
+var supportedFactLanguages = []string{"en", "de"}
+
+var errAIBudgetExceeded = errors.New("daily AI call budget exceeded")
+
+var errAIEmptyChoices = errors.New("no choices in AI response")
+
+// 默认的系统提示词，将英文事实翻译为中文并补充一句解释
+const defaultFactSystemPrompt = `# Role: Random Fact 理解助手
 			## Profile
 			- language: zh_CN
 			- description: 一位专注于帮助用户理解随机趣事实的智能助手，擅长将英文中的冷知识、趣味事实准确翻译并用自然流畅的语言进行解释说明。
@@ -260,113 +230,2170 @@ func (widget *randomFactWidget) processWithAI(text string) (string, error) {
 					它们的膝盖隐藏在厚厚的羽毛和身体结构中，外表看起来像是腿很短，实则具备完整的膝关节。
 
 			## Initialization
-			作为Random Fact 理解助手，你必须遵守上述Rules，按照Workflows执行任务，并按照OutputFormat输出。`,
-			},
-			{
-				"role": "user",
-				"content": text,
-			},
-		},
-		"stream": false,
-		"max_tokens": 512,
-		"response_format": map[string]string{"type": "text"},
+			作为Random Fact 理解助手，你必须遵守上述Rules，按照Workflows执行任务，并按照OutputFormat输出。`
+
+var randomFactWidgetTemplate = mustParseTemplate("random-fact.html", "widget-base.html")
+
+var hexColorPattern = regexp.MustCompile(`^#(?:[0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// RandomFactWidget 配置结构体
+type randomFactWidget struct {
+	widgetBase `yaml:",inline"`
+
+	// API配置
+	APIKey string `yaml:"apikey"`
+	Model  string `yaml:"model"`
+	APIURL string `yaml:"apiurl"`
+
+	// 设置为 false 时即使配置了AI接口也只显示原文，未设置时根据AI配置自动判断
+	AIEnabled *bool `yaml:"ai-enabled"`
+
+	// 每次展示的事实数量
+	Count int `yaml:"count"`
+
+	// 同时展示多条事实时并发进行AI处理的数量
+	AIConcurrency int `yaml:"ai-concurrency"`
+
+	// 以列表形式展示最近的多少条事实，每次更新只获取新的一条
+	ShowHistory int `yaml:"show-history"`
+
+	// 缓存持久化文件，设置后重启时可复用上一次的结果
+	CacheFile string `yaml:"cache-file"`
+
+	// 每日模式，同一天内始终展示同一条事实
+	Daily bool `yaml:"daily"`
+
+	// 去重方式：id、text 或 both
+	DedupeBy string `yaml:"dedupe-by"`
+
+	// 用于去重的最近事实数量，默认10条，负数表示不去重
+	HistorySize int `yaml:"history-size"`
+
+	// 获取事实失败时的重试次数，默认2次，负数表示不重试
+	Retries int `yaml:"retries"`
+
+	// AI接口返回空结果或5xx时的重试次数（仅非流式请求），默认1次，负数表示不重试
+	AIRetries int `yaml:"ai-retries"`
+
+	// 按事实ID缓存的AI处理结果数量，负数表示不缓存
+	TranslationCacheSize int `yaml:"translation-cache-size"`
+
+	// AI处理结果的磁盘缓存文件
+	TranslationCacheFile string `yaml:"translation-cache-file"`
+
+	// 本地事实文件以及事实来源：remote、local 或 local-fallback
+	FactsFile string `yaml:"facts-file"`
+	Source    string `yaml:"source"`
+
+	// 事实API请求失败时改用的备用API，返回格式需与默认API相同
+	FallbackAPIURL string `yaml:"fallback-apiurl"`
+
+	// 事实接口和AI接口的请求超时时间
+	FetchTimeout durationField `yaml:"fetch-timeout"`
+	AITimeout    durationField `yaml:"ai-timeout"`
+
+	// 读取事实API和AI接口响应的最大字节数，防止异常的接口返回过大的内容
+	MaxResponseBytes int64 `yaml:"max-response-bytes"`
+
+	// 更新失败时继续展示上一次成功获取的事实的最长时间，超过后显示错误，为空表示一直展示
+	MaxStaleDuration durationField `yaml:"max-stale"`
+
+	// 事实接口和AI接口请求使用的代理
+	Proxy string `yaml:"proxy"`
+
+	// 只使用HTTP/1.1，用于无法正确处理HTTP/2的代理
+	ForceHTTP1 bool `yaml:"force-http1"`
+
+	// 事实API返回的事实语言
+	Language string `yaml:"language"`
+
+	// 自定义系统提示词和用户提示词，可使用 {{ .Text }} 引用事实原文
+	SystemPrompt string `yaml:"system-prompt"`
+	UserPrompt   string `yaml:"user-prompt"`
+
+	// 从文件中读取系统提示词，收到 SIGHUP 时会重新读取
+	PromptFile string `yaml:"prompt-file"`
+
+	// 未设置 system-prompt 时使用内置的简短提示词将事实翻译为该语言
+	PromptLanguage string `yaml:"prompt-language"`
+
+	// 使用流式方式请求AI接口
+	Stream bool `yaml:"stream"`
+
+	// 非流式响应中AI输出内容的路径，以点分隔，数字表示数组下标，用于返回格式不标准的接口
+	ResponsePath string `yaml:"response-path"`
+
+	// 校验AI输出每行的字数（8-60），不符合时重试一次
+	EnforceLength bool `yaml:"enforce-length"`
+
+	// AI输出因长度限制被截断时，使用更大的token上限重试一次
+	RetryTruncated bool `yaml:"retry-truncated"`
+
+	// 更新被取消时是否保留已流式接收的部分AI输出，默认仅流式模式下保留
+	KeepPartialRaw *bool `yaml:"keep-partial"`
+	KeepPartial    bool  `yaml:"-"`
+
+	// 每天最多调用AI接口的次数，超出后直到当地午夜前都只显示原文，0表示不限制
+	MaxCallsPerDay int `yaml:"max-ai-calls-per-day"`
+
+	// 额外请求一次AI校对翻译是否忠实于原文，不准确时使用修正后的版本
+	SelfCheck bool `yaml:"self-check"`
+
+	// 为每次AI调用生成 Idempotency-Key 请求头，同一调用的重试使用相同的值
+	Idempotency bool `yaml:"idempotency"`
+
+	// 更便宜的备用模型，主模型请求失败或当天调用额度即将用尽时使用
+	CheapModel string `yaml:"cheap-model"`
+
+	// 经过AI处理的事实的来源标签，为空时显示模型名称
+	SourceLabel string `yaml:"source-label"`
+
+	// 卡片的强调色（十六进制），为空时使用主题默认颜色
+	AccentColor string `yaml:"accent-color"`
+
+	// 相对更新时间（如"5分钟前"）使用的语言：zh 或 en，默认 zh
+	RelativeLanguage string `yaml:"relative-language"`
+
+	// AI输出内容的语言，用于页面的 lang 属性
+	OutputLanguage string `yaml:"output-language"`
+
+	// 输出语言为日语时让模型标注汉字读音，并使用 <ruby> 标签展示
+	Ruby bool `yaml:"ruby"`
+
+	// 在AI处理结果下方展示英文原文，方便对照学习
+	ShowOriginal bool `yaml:"show-original"`
+
+	// 术语表，AI处理后将术语统一替换为指定的译法
+	Glossary map[string]string `yaml:"glossary"`
+
+	// 内部状态
+	mu           sync.Mutex
+	refreshing   atomic.Bool
+	lastRender   atomic.Pointer[template.HTML]
+	client       *http.Client
+	aiClient     *http.Client
+	factURL      string
+	history      factHistory
+	translations tieredFactCache
+	remoteCache  factCache
+	glossary     *compiledGlossary
+	localFacts   []rawFactResponse
+	aiCalls      aiCallBudget
+	aiCallsMu    sync.Mutex
+	stats        widgetStats
+	breaker      circuitBreaker
+	customTitle  bool
+	titleTmpl    *texttemplate.Template
+	systemPrompt *texttemplate.Template
+	userPrompt   *texttemplate.Template
+	CachedData   *randomFactData
+	CachedFacts  []*randomFactData
+	FactHistory  []*randomFactData
+	lastUpdate   time.Time
+
+	// 备用API的主机名，作为备用API返回的事实的来源
+	fallbackSource string
+}
+
+// 随机事实数据结构
+type randomFactData struct {
+	FactID     string `json:"fact_id"`
+	FactText   string `json:"fact_text"`
+	Content    string `json:"content"`
+	Source     string `json:"source"`
+	Translated bool   `json:"translated"`
+
+	// 上游来源链接、事实的永久链接和语言，用于追溯事实出处
+	SourceURL string `json:"source_url,omitempty"`
+	Permalink string `json:"permalink,omitempty"`
+	Language  string `json:"language,omitempty"`
+
+	// AI输出拆分后的翻译和解释
+	Translation string `json:"translation,omitempty"`
+	Explanation string `json:"explanation,omitempty"`
+}
+
+// 原始事实API响应
+type rawFactResponse struct {
+	ID        string `json:"id"`
+	Text      string `json:"text"`
+	Source    string `json:"source,omitempty"`
+	SourceURL string `json:"source_url,omitempty"`
+	Language  string `json:"language,omitempty"`
+	Permalink string `json:"permalink,omitempty"`
+
+	// 事实的获取来源，为空时表示远程API
+	origin string
+}
+
+// 流式AI响应中的单个片段
+type aiStreamChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// 持久化到磁盘的缓存内容
+type randomFactCacheFile struct {
+	Data       *randomFactData   `json:"data"`
+	Facts      []*randomFactData `json:"facts,omitempty"`
+	History    [][]string        `json:"history,omitempty"`
+	LastUpdate time.Time         `json:"last_update"`
+}
+
+// AI API响应
+type aiResponse struct {
+	Choices []struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Error *struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+		Code    string `json:"code"`
+	} `json:"error"`
+}
+
+// 初始化随机事实Widget
+func (widget *randomFactWidget) initialize() error {
+	// 缓存时间过短会频繁请求事实API，低于下限时使用下限
+	if widget.CustomCacheDuration < 0 {
+		return fmt.Errorf("cache must not be negative")
+	} else if widget.CustomCacheDuration > 0 && time.Duration(widget.CustomCacheDuration) < minFactCacheDuration {
+		widget.logger().Warn("cache is too short, using the minimum instead", "cache", time.Duration(widget.CustomCacheDuration), "minimum", minFactCacheDuration)
+		widget.CustomCacheDuration = durationField(minFactCacheDuration)
 	}
-	
-	payloadBytes, err := json.Marshal(payload)
-	if err != nil {
-		return "", err
+
+	if err := widget.initTitle(); err != nil {
+		return err
 	}
-	
-	req, err := http.NewRequest("POST", widget.APIURL, bytes.NewBuffer(payloadBytes))
-	if err != nil {
-		return "", err
+
+	widget.withCacheDuration(time.Duration(widget.CustomCacheDuration))
+
+	// 设置默认缓存时间
+	if widget.CustomCacheDuration == 0 {
+		widget.CustomCacheDuration = durationField(defaultFactCacheDuration)
+		widget.withCacheDuration(defaultFactCacheDuration)
 	}
-	
-	req.Header.Set("Authorization", "Bearer "+widget.APIKey)
-	req.Header.Set("Content-Type", "application/json")
-	
-	resp, err := widget.client.Do(req)
-	if err != nil {
-		return "", err
+
+	if widget.Count <= 0 {
+		widget.Count = 1
 	}
-	defer resp.Body.Close()
-	
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("AI API returned status code %d", resp.StatusCode)
+	if widget.Count > maxFactCount {
+		widget.Count = maxFactCount
 	}
-	
-	var aiResp aiResponse
-	if err := json.NewDecoder(resp.Body).Decode(&aiResp); err != nil {
-		return "", err
+
+	if widget.AIConcurrency < 0 {
+		return fmt.Errorf("ai-concurrency must not be negative")
 	}
-	
-	if aiResp.Error != nil {
-		return "", fmt.Errorf("AI API error: %s", aiResp.Error.Message)
+	if widget.AIConcurrency == 0 {
+		widget.AIConcurrency = defaultAIConcurrency
 	}
-	
-	if len(aiResp.Choices) == 0 {
-		return "", fmt.Errorf("no choices in AI response")
+
+	if widget.AccentColor != "" && !hexColorPattern.MatchString(widget.AccentColor) {
+		return fmt.Errorf("invalid accent-color '%s', must be a hex color such as #ff8800", widget.AccentColor)
 	}
-	
-	return aiResp.Choices[0].Message.Content, nil
-}
 
-// 提取模型名称
-func (widget *randomFactWidget) extractModelName() string {
-	// 从模型路径中提取模型名称，如 "Qwen/Qwen3-8B" -> "Qwen3-8B"
-	if len(widget.Model) == 0 {
-		return "unknown"
+	if err := validateRelativeLanguage(widget.RelativeLanguage); err != nil {
+		return err
 	}
-	
-	// 如果包含斜杠，取最后一部分
-	for i := len(widget.Model) - 1; i >= 0; i-- {
-		if widget.Model[i] == '/' {
-			return widget.Model[i+1:]
-		}
+
+	widget.glossary = compileGlossary(widget.Glossary)
+
+	switch widget.DedupeBy {
+	case "":
+		widget.DedupeBy = factDedupeByID
+	case factDedupeByID, factDedupeByText, factDedupeByIDAndText:
+	default:
+		return fmt.Errorf("dedupe-by must be one of: %s, %s, %s", factDedupeByID, factDedupeByText, factDedupeByIDAndText)
 	}
-	
-	return widget.Model
-}
 
-// 渲染Widget
-func (widget *randomFactWidget) Render() template.HTML {
-	if widget.CachedData == nil {
-		fmt.Printf("No cached data available\n")
-		widget.ContentAvailable = false
-		widget.withError(fmt.Errorf("no data available"))
-		return widget.renderTemplate(nil, mustParseTemplate("widget-base.html"))
+	if widget.HistorySize == 0 {
+		widget.HistorySize = defaultFactHistorySize
 	}
-	
-	widget.ContentAvailable = true
-	return widget.renderTemplate(widget, randomFactWidgetTemplate)
-}
 
-// 设置Widget提供者
-func (widget *randomFactWidget) setProviders(providers *widgetProviders) {
-	widget.Providers = providers
-}
+	widget.history.size = max(widget.HistorySize, 0)
 
-// 设置Widget ID
-func (widget *randomFactWidget) setID(id uint64) {
-	widget.ID = id
-}
+	if widget.Source == "" {
+		widget.Source = ternary(widget.FactsFile != "", factSourceLocalFallback, factSourceRemote)
+	}
 
-// 获取Widget类型
-func (widget *randomFactWidget) GetType() string {
-	return "random-fact"
-}
+	switch widget.Source {
+	case factSourceRemote, factSourceLocal, factSourceLocalFallback:
+	default:
+		return fmt.Errorf("source must be one of: %s, %s, %s", factSourceRemote, factSourceLocal, factSourceLocalFallback)
+	}
 
-// 获取Widget ID
-func (widget *randomFactWidget) GetID() uint64 {
-	return widget.ID
-}
+	if widget.FactsFile != "" {
+		facts, err := loadLocalFacts(widget.FactsFile)
+		if err != nil {
+			return fmt.Errorf("loading facts-file: %v", err)
+		}
+		widget.localFacts = facts
+	} else {
+		widget.localFacts = builtInFacts
+	}
 
-// 设置是否隐藏标题
-func (widget *randomFactWidget) setHideHeader(value bool) {
-	widget.HideHeader = value
-}
+	if widget.Retries == 0 {
+		widget.Retries = defaultFactRetries
+	}
 
-// 处理HTTP请求
-func (widget *randomFactWidget) handleRequest(w http.ResponseWriter, r *http.Request) {
-	http.Error(w, "not implemented", http.StatusNotImplemented)
-}
\ No newline at end of file
+	if widget.AIRetries == 0 {
+		widget.AIRetries = defaultAIRetries
+	}
+
+	widget.ResponsePath = strings.TrimSpace(widget.ResponsePath)
+	if widget.ResponsePath == "" {
+		widget.ResponsePath = defaultAIResponsePath
+	} else if widget.ResponsePath != defaultAIResponsePath && widget.Stream {
+		return fmt.Errorf("response-path can't be used together with stream")
+	}
+
+	if widget.TranslationCacheSize == 0 {
+		widget.TranslationCacheSize = defaultTranslationCacheSize
+	}
+
+	widget.initTranslationCache()
+
+	if widget.Language == "" {
+		widget.Language = "en"
+	}
+
+	if !slices.Contains(supportedFactLanguages, widget.Language) {
+		return fmt.Errorf("language must be one of: %s", strings.Join(supportedFactLanguages, ", "))
+	}
+
+	if widget.PromptLanguage != "" {
+		if _, ok := factPromptLanguages[widget.PromptLanguage]; !ok {
+			return fmt.Errorf("prompt-language must be one of: %s", strings.Join(slices.Sorted(maps.Keys(factPromptLanguages)), ", "))
+		}
+
+		// 输出语言默认与提示词的目标语言一致
+		if widget.OutputLanguage == "" {
+			widget.OutputLanguage = widget.PromptLanguage
+		}
+	}
+
+	if widget.Ruby && widget.OutputLanguage != "ja" {
+		return fmt.Errorf("ruby requires output-language to be ja")
+	}
+
+	if widget.factURL == "" {
+		widget.factURL = factAPIURL
+	}
+
+	factURL, err := url.Parse(widget.factURL)
+	if err != nil {
+		return fmt.Errorf("invalid fact API URL: %v", err)
+	}
+
+	query := factURL.Query()
+	query.Set("language", widget.Language)
+	factURL.RawQuery = query.Encode()
+	widget.factURL = factURL.String()
+
+	if widget.FallbackAPIURL != "" {
+		fallbackURL, err := url.Parse(widget.FallbackAPIURL)
+		if err != nil || fallbackURL.Host == "" || (fallbackURL.Scheme != "http" && fallbackURL.Scheme != "https") {
+			return fmt.Errorf("invalid fallback-apiurl '%s', must be a http or https URL", widget.FallbackAPIURL)
+		}
+		widget.fallbackSource = fallbackURL.Host
+	}
+
+	if widget.AIEnabled != nil && !*widget.AIEnabled {
+		widget.logger().Info("AI processing disabled by ai-enabled, will use raw facts only")
+	} else if !widget.hasAIConfig() {
+		widget.logger().Info("AI API not configured, will use raw facts only")
+	}
+
+	if widget.SystemPrompt != "" && widget.PromptFile != "" {
+		return fmt.Errorf("only one of system-prompt and prompt-file can be set")
+	}
+
+	// 提示词模板在初始化时解析，格式错误时直接报错
+	systemPrompt, userPrompt, err := widget.loadPrompts()
+	if err != nil {
+		return err
+	}
+	widget.systemPrompt = systemPrompt
+	widget.userPrompt = userPrompt
+
+	if widget.KeepPartialRaw == nil {
+		widget.KeepPartial = widget.Stream
+	} else {
+		widget.KeepPartial = *widget.KeepPartialRaw
+	}
+
+	if widget.FetchTimeout <= 0 {
+		widget.FetchTimeout = durationField(defaultFactFetchTimeout)
+	}
+
+	if widget.AITimeout <= 0 {
+		widget.AITimeout = durationField(defaultAITimeout)
+	}
+
+	if widget.MaxResponseBytes < 0 {
+		return fmt.Errorf("max-response-bytes must not be negative")
+	}
+
+	if widget.MaxStaleDuration < 0 {
+		return fmt.Errorf("max-stale must not be negative")
+	}
+	if widget.MaxResponseBytes == 0 {
+		widget.MaxResponseBytes = defaultMaxResponseBytes
+	}
+
+	// 默认使用组件间共享的HTTP客户端，超时时间通过请求的context控制
+	// 配置了代理或强制HTTP/1.1时使用单独的客户端
+	widget.client = widget.httpClient()
+
+	if widget.usesOwnTransport() && widget.transportClient == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+
+		if widget.Proxy != "" {
+			proxyURL, err := url.Parse(widget.Proxy)
+			if err != nil || proxyURL.Host == "" || !slices.Contains([]string{"http", "https", "socks5"}, proxyURL.Scheme) {
+				return fmt.Errorf("invalid proxy '%s', must be a http, https or socks5 URL", widget.Proxy)
+			}
+			transport.Proxy = http.ProxyURL(proxyURL)
+		}
+
+		// 非nil的空 TLSNextProto 会禁用HTTP/2
+		if widget.ForceHTTP1 {
+			transport.ForceAttemptHTTP2 = false
+			transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		}
+
+		widget.client = &http.Client{Transport: transport}
+	}
+
+	widget.aiClient = widget.client
+
+	// 从磁盘加载缓存，失败时退回正常获取流程
+	if widget.CacheFile != "" {
+		if err := widget.loadCacheFile(); err != nil {
+			widget.logger().Warn("Could not load random fact cache file", "file", widget.CacheFile, "error", err)
+		}
+	}
+
+	return nil
+}
+
+// 更新数据
+func (widget *randomFactWidget) update(ctx context.Context) {
+	widget.mu.Lock()
+	defer widget.mu.Unlock()
+
+	widget.updateLocked(ctx, false)
+}
+
+// 更新数据，调用方需持有 widget.mu
+// force 为 true 时忽略仍然有效的缓存，立即获取新的事实
+func (widget *randomFactWidget) updateLocked(ctx context.Context, force bool) {
+	// 检查缓存是否有效
+	if !force && widget.CachedData != nil && time.Now().Before(widget.cacheExpiry(widget.lastUpdate)) {
+		widget.stats.cacheHits.Add(1)
+
+		// 每日模式下当天的事实保持不变，只重试尚未成功的AI处理
+		if widget.Daily && widget.hasAIConfig() && !widget.allFactsTranslated() {
+			widget.retryDailyTranslation(ctx)
+		}
+		return
+	}
+
+	// 事实接口持续失败时在退避期间内不再请求
+	if widget.breaker.isOpen() {
+		return
+	}
+
+	// 获取原始事实数据
+	rawFacts, err := widget.fetchFactBatch(ctx)
+	if err != nil {
+		widget.breaker.handleFailure(&widget.widgetBase, widget.logger(), "Failed to fetch raw fact", err)
+		return
+	}
+	widget.breaker.recordSuccess()
+
+	// 更新缓存数据
+	facts := widget.processFacts(ctx, rawFacts)
+
+	widget.setCachedFacts(facts)
+	widget.recordFactHistory(facts)
+	widget.lastUpdate = time.Now()
+	widget.withError(nil)
+	widget.scheduleFactUpdate()
+	widget.persistCache()
+}
+
+// 获取 Count 条ID互不相同的事实，重复时有限次数地重新获取
+// 已获取到部分事实时遇到错误则返回已有的事实
+func (widget *randomFactWidget) fetchFactBatch(ctx context.Context) ([]*rawFactResponse, error) {
+	facts := make([]*rawFactResponse, 0, widget.Count)
+	seen := make(map[string]struct{}, widget.Count)
+
+	for attempts := 0; len(facts) < widget.Count && attempts < widget.Count*maxFactFetchAttempts; attempts++ {
+		fact, err := widget.fetchUniqueFact(ctx)
+		if err != nil {
+			if len(facts) > 0 {
+				widget.logger().Warn("Failed to fetch all facts, showing partial batch", "fetched", len(facts), "error", err)
+				break
+			}
+			return nil, err
+		}
+
+		if _, exists := seen[fact.ID]; exists {
+			continue
+		}
+
+		seen[fact.ID] = struct{}{}
+		facts = append(facts, fact)
+	}
+
+	return facts, nil
+}
+
+// 设置缓存的事实列表，CachedData 始终指向第一条
+func (widget *randomFactWidget) setCachedFacts(facts []*randomFactData) {
+	widget.CachedFacts = facts
+	widget.CachedData = nil
+
+	if len(facts) > 0 {
+		widget.CachedData = facts[0]
+	}
+
+	widget.updateTitle()
+}
+
+// 将新获取的事实加入展示历史，最新的在前，最多保留 ShowHistory 条
+func (widget *randomFactWidget) recordFactHistory(facts []*randomFactData) {
+	if widget.ShowHistory <= 1 {
+		return
+	}
+
+	history := make([]*randomFactData, 0, widget.ShowHistory)
+	for i := len(facts) - 1; i >= 0 && len(history) < widget.ShowHistory; i-- {
+		history = append(history, facts[i])
+	}
+
+	for _, fact := range widget.FactHistory {
+		if len(history) >= widget.ShowHistory {
+			break
+		}
+		history = append(history, fact)
+	}
+
+	widget.FactHistory = history
+}
+
+// 模板中以列表展示的事实：开启历史时为最近的事实，否则为本次获取的事实
+func (widget *randomFactWidget) DisplayedFacts() []*randomFactData {
+	if widget.ShowHistory > 1 {
+		return widget.FactHistory
+	}
+
+	return widget.CachedFacts
+}
+
+// 所有缓存的事实是否都已成功经过AI处理
+func (widget *randomFactWidget) allFactsTranslated() bool {
+	for _, fact := range widget.CachedFacts {
+		if !fact.Translated {
+			return false
+		}
+	}
+
+	return true
+}
+
+// 最多使用 AIConcurrency 个并发对一批事实进行处理，结果保持原有顺序
+// 单条事实AI处理失败时只有该条使用原文，更新被取消时尚未开始处理的事实同样使用原文
+func (widget *randomFactWidget) processFacts(ctx context.Context, rawFacts []*rawFactResponse) []*randomFactData {
+	job := newJob(func(rawFact *rawFactResponse) (*randomFactData, error) {
+		return widget.processFact(ctx, rawFact), nil
+	}, rawFacts).withWorkers(widget.AIConcurrency).withContext(ctx)
+
+	facts, _, _ := workerPoolDo(job)
+	for i, fact := range facts {
+		if fact == nil {
+			facts[i] = newRandomFactData(rawFacts[i])
+		}
+	}
+
+	return facts
+}
+
+// 未经AI处理的展示数据
+func newRandomFactData(rawFact *rawFactResponse) *randomFactData {
+	return &randomFactData{
+		FactID:    rawFact.ID,
+		FactText:  rawFact.Text,
+		Content:   rawFact.Text,
+		Source:    cmp.Or(rawFact.origin, "uselessfacts.jsph.pl"),
+		SourceURL: rawFact.SourceURL,
+		Permalink: rawFact.Permalink,
+		Language:  rawFact.Language,
+	}
+}
+
+// 将原始事实转换为展示数据，配置了AI时进行AI处理
+// 可能被并发调用，访问共享状态的部分需要自行加锁
+func (widget *randomFactWidget) processFact(ctx context.Context, rawFact *rawFactResponse) *randomFactData {
+	data := newRandomFactData(rawFact)
+
+	if !widget.hasAIConfig() {
+		return data
+	}
+
+	data.Source = widget.aiSourceLabel(widget.Model)
+
+	// 如果AI处理失败，使用原始文本
+	content, model, err := widget.translate(ctx, rawFact.ID, rawFact.Text)
+	if err != nil {
+		widget.stats.aiFallbacks.Add(1)
+
+		if !errors.Is(err, errAIBudgetExceeded) {
+			widget.logger().Warn("AI processing failed, using raw fact text", "fact_id", rawFact.ID, "error", err)
+		}
+		return data
+	}
+
+	data.setAIContent(content)
+	data.Source = widget.aiSourceLabel(model)
+
+	// 被取消时保留的只是部分输出，每日模式下稍后仍需重试
+	if ctx.Err() != nil {
+		data.Translated = false
+	}
+
+	return data
+}
+
+// 每日模式下对当天已缓存的事实重新进行AI处理
+func (widget *randomFactWidget) retryDailyTranslation(ctx context.Context) {
+	for _, fact := range widget.CachedFacts {
+		if fact.Translated {
+			continue
+		}
+
+		content, model, err := widget.translate(ctx, fact.FactID, fact.FactText)
+		if errors.Is(err, errAIBudgetExceeded) {
+			break
+		}
+		if err != nil {
+			widget.logger().Warn("Retrying AI processing of daily fact failed", "fact_id", fact.FactID, "error", err)
+			continue
+		}
+
+		fact.setAIContent(content)
+		fact.Source = widget.aiSourceLabel(model)
+		fact.Translated = ctx.Err() == nil
+	}
+
+	widget.updateTitle()
+	widget.scheduleFactUpdate()
+	widget.persistCache()
+}
+
+// 对事实进行AI处理，相同ID的事实复用之前的结果，同时返回生成结果的模型
+// 缓存中的结果视为由主模型生成
+func (widget *randomFactWidget) translate(ctx context.Context, factID string, text string) (string, string, error) {
+	if content, ok := widget.translations.Get(factID); ok {
+		widget.stats.cacheHits.Add(1)
+		return content, widget.Model, nil
+	}
+
+	content, model, err := widget.processWithAI(ctx, text, "")
+	if err != nil {
+		return "", "", err
+	}
+
+	content, err = normalizeAIOutput(content)
+	if err != nil {
+		return "", "", err
+	}
+
+	// 行长度不符合要求时使用更严格的指令重试一次，之后无论结果如何都接受
+	if widget.EnforceLength && !aiOutputWithinLengthBounds(content) {
+		widget.logger().Debug("AI output lines are out of length bounds, retrying", "fact_id", factID)
+
+		if retried, retriedModel, err := widget.processWithAI(ctx, text, aiLengthRetryInstruction); err == nil {
+			if retried, err = normalizeAIOutput(retried); err == nil {
+				content, model = retried, retriedModel
+			}
+		}
+	}
+
+	if widget.SelfCheck {
+		content, model = widget.selfCheck(ctx, factID, text, content, model)
+	}
+
+	content = widget.glossary.apply(content)
+
+	// 被取消时得到的部分输出不写入缓存
+	if ctx.Err() == nil {
+		widget.translations.Set(factID, content)
+	}
+
+	return content, model, nil
+}
+
+// 让模型校对翻译是否忠实于原文，不准确时使用修正后的版本
+// 只额外请求一次，校对失败或输出被截断时保留原来的结果
+func (widget *randomFactWidget) selfCheck(ctx context.Context, factID string, text string, content string, model string) (string, string) {
+	messages := []map[string]string{
+		{
+			"role":    "system",
+			"content": aiSelfCheckPrompt,
+		},
+		{
+			"role":    "user",
+			"content": fmt.Sprintf("英文原文：%s\n中文输出：\n%s", text, content),
+		},
+	}
+
+	checked, finishReason, err := widget.requestAICompletion(widget.withIdempotencyKey(ctx), model, messages, aiMaxTokens)
+	if err == nil && finishReason == aiFinishReasonLength {
+		err = errors.New("self-check output was truncated")
+	}
+	if err == nil {
+		checked, err = normalizeAIOutput(checked)
+	}
+	if err != nil {
+		widget.logger().Debug("AI self-check failed, keeping the original output", "fact_id", factID, "error", err)
+		return content, model
+	}
+
+	if checked != content {
+		widget.logger().Debug("AI self-check corrected the output", "fact_id", factID, "original", content, "corrected", checked)
+	}
+
+	return checked, model
+}
+
+// 整理AI输出并校验是否符合“翻译+解释”两行的约定
+func normalizeAIOutput(content string) (string, error) {
+	var lines []string
+	for _, line := range strings.Split(content, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	if len(lines) == 0 {
+		return "", errors.New("AI output is empty")
+	}
+
+	normalized := strings.Join(lines, "\n")
+
+	if strings.Contains(normalized, aiRefusalSentinel) {
+		return "", fmt.Errorf("AI refused to process the fact: %s", normalized)
+	}
+
+	if len(lines) > 2 {
+		return "", fmt.Errorf("AI output has %d lines, expected 2: %q", len(lines), normalized)
+	}
+
+	return normalized, nil
+}
+
+// 检查AI输出的每一行是否在规定的字数范围内
+func aiOutputWithinLengthBounds(content string) bool {
+	for _, line := range strings.Split(content, "\n") {
+		length := utf8.RuneCountInString(line)
+		if length < aiOutputMinLineLength || length > aiOutputMaxLineLength {
+			return false
+		}
+	}
+
+	return true
+}
+
+// 设置AI处理后的内容，并拆分出翻译和解释两部分
+func (data *randomFactData) setAIContent(content string) {
+	data.Content = content
+	data.Translated = true
+	data.Translation, data.Explanation, _ = strings.Cut(content, "\n")
+}
+
+// 转义AI输出用于展示，开启 ruby 时将读音标注转换为 <ruby> 标签
+// 没有标注或未开启时按普通文本展示
+func (widget *randomFactWidget) FormatText(text string) template.HTML {
+	if !widget.Ruby {
+		return template.HTML(html.EscapeString(text))
+	}
+
+	var formatted strings.Builder
+	last := 0
+	for _, match := range rubyAnnotationPattern.FindAllStringSubmatchIndex(text, -1) {
+		formatted.WriteString(html.EscapeString(text[last:match[0]]))
+		formatted.WriteString("<ruby>")
+		formatted.WriteString(html.EscapeString(text[match[2]:match[3]]))
+		formatted.WriteString("<rp>(</rp><rt>")
+		formatted.WriteString(html.EscapeString(text[match[4]:match[5]]))
+		formatted.WriteString("</rt><rp>)</rp></ruby>")
+		last = match[1]
+	}
+	formatted.WriteString(html.EscapeString(text[last:]))
+
+	return template.HTML(formatted.String())
+}
+
+// 编译后的术语表，所有术语合并为一个正则以便单次替换
+type compiledGlossary struct {
+	pattern      *regexp.Regexp
+	replacements map[string]string
+}
+
+// 编译术语表，较长的术语优先匹配，术语首尾为字母或数字时按单词边界匹配
+func compileGlossary(glossary map[string]string) *compiledGlossary {
+	terms := make([]string, 0, len(glossary))
+	for term := range glossary {
+		if term != "" {
+			terms = append(terms, term)
+		}
+	}
+
+	if len(terms) == 0 {
+		return nil
+	}
+
+	slices.SortFunc(terms, func(a, b string) int {
+		if len(a) != len(b) {
+			return len(b) - len(a)
+		}
+		return strings.Compare(a, b)
+	})
+
+	alternatives := make([]string, 0, len(terms))
+	for _, term := range terms {
+		expr := regexp.QuoteMeta(term)
+		if isASCIIWordByte(term[0]) {
+			expr = `\b` + expr
+		}
+		if isASCIIWordByte(term[len(term)-1]) {
+			expr = expr + `\b`
+		}
+		alternatives = append(alternatives, expr)
+	}
+
+	return &compiledGlossary{
+		pattern:      regexp.MustCompile(strings.Join(alternatives, "|")),
+		replacements: glossary,
+	}
+}
+
+func isASCIIWordByte(b byte) bool {
+	return b == '_' || (b >= '0' && b <= '9') || (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+// 将术语表应用到AI输出
+func (g *compiledGlossary) apply(content string) string {
+	if g == nil {
+		return content
+	}
+
+	return g.pattern.ReplaceAllStringFunc(content, func(term string) string {
+		return g.replacements[term]
+	})
+}
+
+// 组装AI处理结果的分层缓存：内存、磁盘（可选）、远程（可选）
+func (widget *randomFactWidget) initTranslationCache() {
+	tiers := []factCache{&factTranslationCache{size: widget.TranslationCacheSize}}
+
+	if widget.TranslationCacheFile != "" {
+		tiers = append(tiers, &diskFactCache{
+			path:  widget.TranslationCacheFile,
+			cache: factTranslationCache{size: max(widget.TranslationCacheSize, defaultTranslationCacheSize)},
+		})
+	}
+
+	if widget.remoteCache != nil {
+		tiers = append(tiers, widget.remoteCache)
+	}
+
+	widget.translations = tieredFactCache{tiers: tiers}
+}
+
+// AI处理结果的缓存层，远程缓存需实现此接口
+type factCache interface {
+	Get(key string) (string, bool)
+	Set(key string, value string)
+}
+
+// 分层缓存，按顺序查找各层，命中时回填到更靠前的层
+// 批量处理事实时会被并发访问
+type tieredFactCache struct {
+	mu    sync.Mutex
+	tiers []factCache
+}
+
+func (c *tieredFactCache) Get(key string) (string, bool) {
+	if key == "" {
+		return "", false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, tier := range c.tiers {
+		if value, ok := tier.Get(key); ok {
+			for j := range i {
+				c.tiers[j].Set(key, value)
+			}
+			return value, true
+		}
+	}
+
+	return "", false
+}
+
+func (c *tieredFactCache) Set(key string, value string) {
+	if key == "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, tier := range c.tiers {
+		tier.Set(key, value)
+	}
+}
+
+// 按事实ID缓存的AI处理结果，超出容量时先进先出
+type factTranslationCache struct {
+	size     int
+	contents map[string]string
+	order    []string
+}
+
+func (c *factTranslationCache) Get(factID string) (string, bool) {
+	content, ok := c.contents[factID]
+	return content, ok
+}
+
+func (c *factTranslationCache) Set(factID string, content string) {
+	if c.size <= 0 {
+		return
+	}
+
+	if c.contents == nil {
+		c.contents = make(map[string]string)
+	}
+
+	if _, exists := c.contents[factID]; !exists {
+		c.order = append(c.order, factID)
+	}
+	c.contents[factID] = content
+
+	for len(c.order) > c.size {
+		delete(c.contents, c.order[0])
+		c.order = c.order[1:]
+	}
+}
+
+// 持久化到磁盘的AI处理结果缓存，首次读取时从文件加载
+type diskFactCache struct {
+	path   string
+	cache  factTranslationCache
+	loaded bool
+}
+
+type diskFactCacheEntry struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+func (c *diskFactCache) load() {
+	if c.loaded {
+		return
+	}
+	c.loaded = true
+
+	contents, err := os.ReadFile(c.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Warn("Could not read translation cache file", "file", c.path, "error", err)
+		}
+		return
+	}
+
+	var entries []diskFactCacheEntry
+	if err := json.Unmarshal(contents, &entries); err != nil {
+		slog.Warn("Could not parse translation cache file", "file", c.path, "error", err)
+		return
+	}
+
+	for _, entry := range entries {
+		c.cache.Set(entry.Key, entry.Value)
+	}
+}
+
+func (c *diskFactCache) Get(key string) (string, bool) {
+	c.load()
+	return c.cache.Get(key)
+}
+
+func (c *diskFactCache) Set(key string, value string) {
+	c.load()
+	c.cache.Set(key, value)
+
+	entries := make([]diskFactCacheEntry, 0, len(c.cache.order))
+	for _, key := range c.cache.order {
+		entries = append(entries, diskFactCacheEntry{Key: key, Value: c.cache.contents[key]})
+	}
+
+	contents, err := json.Marshal(entries)
+	if err == nil {
+		err = os.WriteFile(c.path, contents, 0o644)
+	}
+
+	if err != nil {
+		slog.Warn("Could not save translation cache file", "file", c.path, "error", err)
+	}
+}
+
+// 带有Widget类型和ID的日志记录器
+func (widget *randomFactWidget) logger() *slog.Logger {
+	return slog.With("widget", widget.GetType(), "widget_id", widget.ID)
+}
+
+// 返回请求事实API和AI接口的统计数据
+func (widget *randomFactWidget) Stats() widgetStatsSnapshot {
+	return widget.stats.snapshot()
+}
+
+// 是否配置了AI API参数
+func (widget *randomFactWidget) hasAIConfig() bool {
+	if widget.AIEnabled != nil && !*widget.AIEnabled {
+		return false
+	}
+
+	return widget.APIKey != "" && widget.Model != "" && widget.APIURL != ""
+}
+
+// 是否需要使用单独的而不是共享的HTTP客户端
+func (widget *randomFactWidget) usesOwnTransport() bool {
+	return widget.Proxy != "" || widget.ForceHTTP1
+}
+
+// 供模板判断事实是否经过AI处理
+func (widget *randomFactWidget) UsesAI() bool {
+	return widget.hasAIConfig()
+}
+
+// 计算缓存的过期时间，每日模式下缓存到当地时间的午夜
+func (widget *randomFactWidget) cacheExpiry(lastUpdate time.Time) time.Time {
+	if widget.Daily {
+		year, month, day := lastUpdate.Date()
+		return time.Date(year, month, day+1, 0, 0, 0, 0, lastUpdate.Location())
+	}
+
+	return lastUpdate.Add(time.Duration(widget.CustomCacheDuration))
+}
+
+// 安排下一次更新
+func (widget *randomFactWidget) scheduleFactUpdate() {
+	// 每日模式下如果翻译失败则尽早重试，连续失败时重试间隔逐渐变长
+	// 翻译成功前不能调用 scheduleNextUpdate，否则失败次数会被清零
+	if widget.Daily && widget.hasAIConfig() && !widget.allFactsTranslated() {
+		widget.scheduleEarlyUpdate()
+		return
+	}
+
+	widget.scheduleNextUpdate()
+
+	// 每日模式下固定到午夜
+	if widget.Daily {
+		widget.nextUpdate = widget.cacheExpiry(widget.lastUpdate)
+	}
+}
+
+// 将缓存写入磁盘（如果配置了缓存文件）
+func (widget *randomFactWidget) persistCache() {
+	if widget.CacheFile == "" {
+		return
+	}
+
+	if err := widget.saveCacheFile(); err != nil {
+		widget.logger().Warn("Could not save random fact cache file", "file", widget.CacheFile, "error", err)
+	}
+}
+
+// 从缓存文件加载数据，仅在缓存仍然有效时使用
+func (widget *randomFactWidget) loadCacheFile() error {
+	contents, err := os.ReadFile(widget.CacheFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var cached randomFactCacheFile
+	if err := json.Unmarshal(contents, &cached); err != nil {
+		return fmt.Errorf("parsing %s: %v", widget.CacheFile, err)
+	}
+
+	// 去重历史与缓存是否过期无关，始终恢复
+	widget.history.restore(cached.History)
+
+	if len(cached.Facts) == 0 && cached.Data != nil {
+		cached.Facts = []*randomFactData{cached.Data}
+	}
+
+	if len(cached.Facts) == 0 || cached.LastUpdate.IsZero() {
+		return nil
+	}
+
+	expiry := widget.cacheExpiry(cached.LastUpdate)
+	if !time.Now().Before(expiry) {
+		return nil
+	}
+
+	widget.setCachedFacts(cached.Facts)
+	widget.lastUpdate = cached.LastUpdate
+	widget.nextUpdate = expiry
+
+	return nil
+}
+
+// 将当前缓存写入磁盘
+func (widget *randomFactWidget) saveCacheFile() error {
+	contents, err := json.Marshal(randomFactCacheFile{
+		Data:       widget.CachedData,
+		Facts:      widget.CachedFacts,
+		History:    widget.history.entries,
+		LastUpdate: widget.lastUpdate,
+	})
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(widget.CacheFile, contents, 0o644)
+}
+
+// 获取一条最近未展示过的事实，多次重复后接受最后一次结果
+func (widget *randomFactWidget) fetchUniqueFact(ctx context.Context) (*rawFactResponse, error) {
+	var fact *rawFactResponse
+	var err error
+
+	for range maxFactFetchAttempts {
+		fact, err = widget.fetchRawFact(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if !widget.history.contains(widget.dedupeKeys(fact)...) {
+			break
+		}
+	}
+
+	widget.history.add(widget.dedupeKeys(fact)...)
+
+	return fact, nil
+}
+
+// 根据去重方式生成事实的去重键
+func (widget *randomFactWidget) dedupeKeys(fact *rawFactResponse) []string {
+	keys := make([]string, 0, 2)
+
+	if widget.DedupeBy != factDedupeByText && fact.ID != "" {
+		keys = append(keys, "id:"+fact.ID)
+	}
+
+	if widget.DedupeBy != factDedupeByID {
+		keys = append(keys, "text:"+normalizedTextHash(fact.Text))
+	}
+
+	return keys
+}
+
+// 忽略大小写、标点和空白差异后计算文本哈希
+func normalizedTextHash(text string) string {
+	var builder strings.Builder
+
+	for _, word := range strings.Fields(strings.ToLower(text)) {
+		word = strings.TrimFunc(word, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+		})
+
+		if word == "" {
+			continue
+		}
+
+		if builder.Len() > 0 {
+			builder.WriteByte(' ')
+		}
+		builder.WriteString(word)
+	}
+
+	hash := fnv.New64a()
+	hash.Write([]byte(builder.String()))
+
+	return strconv.FormatUint(hash.Sum64(), 16)
+}
+
+// 最近展示过的事实的去重键，每条事实一项，超出长度时先进先出
+type factHistory struct {
+	size    int
+	entries [][]string
+}
+
+func (h *factHistory) contains(keys ...string) bool {
+	for _, entry := range h.entries {
+		for _, key := range keys {
+			if slices.Contains(entry, key) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (h *factHistory) add(keys ...string) {
+	if h.size <= 0 {
+		return
+	}
+
+	h.entries = append(h.entries, keys)
+	h.trim()
+}
+
+// 用持久化的历史替换当前历史，超出长度的旧记录会被丢弃
+func (h *factHistory) restore(entries [][]string) {
+	h.entries = entries
+	h.trim()
+}
+
+func (h *factHistory) trim() {
+	if len(h.entries) > h.size {
+		h.entries = h.entries[len(h.entries)-h.size:]
+	}
+}
+
+// 根据事实来源配置获取原始事实数据
+// 未配置 facts-file 时 local 模式使用内置的事实列表，remote 模式在请求失败时也会回退到内置列表
+func (widget *randomFactWidget) fetchRawFact(ctx context.Context) (*rawFactResponse, error) {
+	switch widget.Source {
+	case factSourceLocal:
+		return widget.pickLocalFact(), nil
+	case factSourceRemote:
+		if widget.FactsFile != "" {
+			return widget.fetchRemoteFact(ctx)
+		}
+	}
+
+	fact, err := widget.fetchRemoteFact(ctx)
+	if err != nil && len(widget.localFacts) > 0 && ctx.Err() == nil {
+		widget.logger().Warn("Failed to fetch remote fact, using local facts", "source", widget.localFacts[0].origin, "error", err)
+		return widget.pickLocalFact(), nil
+	}
+
+	return fact, err
+}
+
+// 从本地事实文件或内置列表中随机选取一条
+func (widget *randomFactWidget) pickLocalFact() *rawFactResponse {
+	fact := widget.localFacts[rand.IntN(len(widget.localFacts))]
+	return &fact
+}
+
+// 从远程API获取原始事实数据，默认API失败时尝试 fallback-apiurl
+func (widget *randomFactWidget) fetchRemoteFact(ctx context.Context) (*rawFactResponse, error) {
+	fact, err := widget.fetchRemoteFactFrom(ctx, widget.factURL)
+	if err == nil || widget.FallbackAPIURL == "" || ctx.Err() != nil {
+		return fact, err
+	}
+
+	widget.logger().Warn("Failed to fetch fact, trying fallback API", "fallback_apiurl", widget.FallbackAPIURL, "error", err)
+
+	fact, fallbackErr := widget.fetchRemoteFactFrom(ctx, widget.FallbackAPIURL)
+	if fallbackErr != nil {
+		return nil, fmt.Errorf("%w; fallback API: %w", err, fallbackErr)
+	}
+
+	fact.origin = widget.fallbackSource
+	return fact, nil
+}
+
+// 从指定的事实API获取原始事实数据，网络错误和5xx/429时按指数退避重试
+func (widget *randomFactWidget) fetchRemoteFactFrom(ctx context.Context, apiURL string) (*rawFactResponse, error) {
+	for attempt := 0; ; attempt++ {
+		fact, retryable, err := widget.fetchRawFactOnce(ctx, apiURL)
+		widget.stats.recordFetch(err)
+		if err == nil || !retryable || attempt >= widget.Retries {
+			return fact, err
+		}
+
+		if err := sleepWithContext(ctx, retryBackoffDelay(attempt)); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// 加载本地事实文件，支持JSON数组、每行一个JSON对象或每行一条纯文本
+func loadLocalFacts(path string) ([]rawFactResponse, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseLocalFacts(path, contents)
+}
+
+// 解析本地事实列表的内容，path 用于错误信息
+func parseLocalFacts(path string, contents []byte) ([]rawFactResponse, error) {
+	var err error
+	var facts []rawFactResponse
+	trimmed := bytes.TrimSpace(contents)
+
+	if bytes.HasPrefix(trimmed, []byte("[")) {
+		var entries []json.RawMessage
+		if err := json.Unmarshal(trimmed, &entries); err != nil {
+			return nil, fmt.Errorf("parsing %s: %v", path, err)
+		}
+
+		for _, entry := range entries {
+			fact, err := parseLocalFact(entry)
+			if err != nil {
+				return nil, fmt.Errorf("parsing %s: %v", path, err)
+			}
+			facts = append(facts, fact)
+		}
+	} else {
+		for i, line := range strings.Split(string(trimmed), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" {
+				continue
+			}
+
+			var fact rawFactResponse
+			if strings.HasPrefix(line, "{") {
+				if fact, err = parseLocalFact([]byte(line)); err != nil {
+					return nil, fmt.Errorf("parsing %s line %d: %v", path, i+1, err)
+				}
+			} else {
+				fact.Text = line
+			}
+
+			facts = append(facts, fact)
+		}
+	}
+
+	facts = slices.DeleteFunc(facts, func(fact rawFactResponse) bool {
+		return fact.Text == ""
+	})
+
+	for i := range facts {
+		facts[i].origin = factSourceLocal
+		if facts[i].ID == "" {
+			facts[i].ID = "local-" + normalizedTextHash(facts[i].Text)
+		}
+	}
+
+	if len(facts) == 0 {
+		return nil, fmt.Errorf("no facts found in %s", path)
+	}
+
+	return facts, nil
+}
+
+// 解析本地事实文件中的单条记录，可以是字符串或对象
+func parseLocalFact(entry []byte) (rawFactResponse, error) {
+	var fact rawFactResponse
+
+	var text string
+	if err := json.Unmarshal(entry, &text); err == nil {
+		fact.Text = text
+		return fact, nil
+	}
+
+	err := json.Unmarshal(entry, &fact)
+	return fact, err
+}
+
+// 单次获取原始事实数据，返回的布尔值表示错误是否可以重试
+func (widget *randomFactWidget) fetchRawFactOnce(ctx context.Context, apiURL string) (*rawFactResponse, bool, error) {
+	requestCtx, cancel := context.WithTimeout(ctx, time.Duration(widget.FetchTimeout))
+	defer cancel()
+
+	var fact rawFactResponse
+	if err := fetchJSONWithLimit(requestCtx, widget.client, "GET", apiURL, nil, nil, widget.MaxResponseBytes, &fact); err != nil {
+		return nil, isRetryableFetchError(ctx, err), err
+	}
+
+	// 接口返回空对象时不当作有效的事实展示
+	if strings.TrimSpace(fact.Text) == "" {
+		return nil, false, errors.New("fact API returned an empty fact")
+	}
+
+	return &fact, false, nil
+}
+
+// 使用AI处理事实内容，返回内容和实际使用的模型
+// 当天的调用额度即将用尽或主模型请求失败时改用 cheap-model
+func (widget *randomFactWidget) processWithAI(ctx context.Context, text string, instruction string) (string, string, error) {
+	if widget.APIKey == "" {
+		return "", "", fmt.Errorf("API key not configured")
+	}
+
+	messages, err := widget.factMessages(text, instruction)
+	if err != nil {
+		return "", "", err
+	}
+
+	return widget.completeMessages(widget.withIdempotencyKey(ctx), messages)
+}
+
+// 发送消息并返回内容和实际使用的模型，必要时改用 cheap-model
+func (widget *randomFactWidget) completeMessages(ctx context.Context, messages []map[string]string) (string, string, error) {
+	model := widget.Model
+	if widget.CheapModel != "" && widget.aiBudgetNearlyExhausted() {
+		model = widget.CheapModel
+	}
+
+	content, err := widget.completeWithModel(ctx, model, messages)
+	if err != nil && model != widget.CheapModel && widget.CheapModel != "" && !errors.Is(err, errAIBudgetExceeded) && ctx.Err() == nil {
+		widget.logger().Warn("Primary AI model failed, falling back to cheap model", "model", widget.Model, "cheap_model", widget.CheapModel, "error", err)
+		model = widget.CheapModel
+		content, err = widget.completeWithModel(ctx, model, messages)
+	}
+
+	if err != nil {
+		return "", "", err
+	}
+
+	return content, model, nil
+}
+
+// 使用指定模型发送消息，输出因长度限制被截断时按配置重试或标记
+func (widget *randomFactWidget) completeWithModel(ctx context.Context, model string, messages []map[string]string) (string, error) {
+	content, finishReason, err := widget.requestAICompletion(ctx, model, messages, aiMaxTokens)
+	if err != nil {
+		return "", err
+	}
+
+	if finishReason == aiFinishReasonLength && widget.RetryTruncated {
+		widget.logger().Debug("AI output was truncated, retrying with a larger token budget")
+
+		retryContent, retryFinishReason, err := widget.requestAICompletion(ctx, model, messages, aiRetryMaxTokens)
+		if err == nil {
+			content, finishReason = retryContent, retryFinishReason
+		}
+	}
+
+	if finishReason == aiFinishReasonLength || finishReason == aiFinishReasonCancelled {
+		content = strings.TrimRightFunc(content, unicode.IsSpace) + "…"
+	}
+
+	return content, nil
+}
+
+type aiIdempotencyKeyContextKey struct{}
+
+// 开启 idempotency 时为一次逻辑上的AI调用生成新的幂等键
+// 该调用内的重试（如截断后重试、改用备用模型）都会携带同一个键
+func (widget *randomFactWidget) withIdempotencyKey(ctx context.Context) context.Context {
+	if !widget.Idempotency {
+		return ctx
+	}
+
+	return context.WithValue(ctx, aiIdempotencyKeyContextKey{}, cryptorand.Text())
+}
+
+// 根据提示词模板生成处理事实的消息
+// instruction 不为空时作为额外的系统消息附加在提示词之后
+func (widget *randomFactWidget) factMessages(text string, instruction string) ([]map[string]string, error) {
+	systemPrompt, err := renderFactPrompt(widget.systemPrompt, text)
+	if err != nil {
+		return nil, err
+	}
+
+	userPrompt, err := renderFactPrompt(widget.userPrompt, text)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := []map[string]string{
+		{
+			"role":    "system",
+			"content": systemPrompt,
+		},
+	}
+
+	if widget.Ruby {
+		messages = append(messages, map[string]string{
+			"role":    "system",
+			"content": aiRubyInstruction,
+		})
+	}
+
+	if instruction != "" {
+		messages = append(messages, map[string]string{
+			"role":    "system",
+			"content": instruction,
+		})
+	}
+
+	messages = append(messages, map[string]string{
+		"role":    "user",
+		"content": userPrompt,
+	})
+
+	return messages, nil
+}
+
+// 发送AI请求，返回内容和结束原因，非流式请求失败时按 ai-retries 重试
+func (widget *randomFactWidget) requestAICompletion(ctx context.Context, model string, messages []map[string]string, maxTokens int) (string, string, error) {
+	payload := map[string]interface{}{
+		"model":           model,
+		"messages":        messages,
+		"stream":          widget.Stream,
+		"max_tokens":      maxTokens,
+		"response_format": map[string]string{"type": "text"},
+	}
+
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return "", "", err
+	}
+
+	headers := map[string]string{
+		"Authorization": "Bearer " + widget.APIKey,
+		"Content-Type":  "application/json",
+	}
+
+	if key, ok := ctx.Value(aiIdempotencyKeyContextKey{}).(string); ok {
+		headers["Idempotency-Key"] = key
+	}
+
+	if !widget.Stream {
+		return widget.fetchAICompletion(ctx, headers, payloadBytes)
+	}
+
+	if !widget.takeAICall() {
+		return "", "", errAIBudgetExceeded
+	}
+
+	requestCtx, cancel := context.WithTimeout(ctx, time.Duration(widget.AITimeout))
+	defer cancel()
+
+	resp, err := fetchResponse(requestCtx, widget.aiClient, "POST", widget.APIURL, headers, bytes.NewReader(payloadBytes))
+	if err != nil {
+		widget.stats.recordFetch(err)
+		return "", "", aiRequestError(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := limitedResponseBody(resp, widget.MaxResponseBytes)
+	if err != nil {
+		widget.stats.recordFetch(err)
+		return "", "", err
+	}
+
+	content, finishReason, err := readAIStream(body)
+	widget.stats.recordFetch(err)
+	if err != nil && ctx.Err() != nil && widget.KeepPartial && content != "" {
+		widget.logger().Debug("AI request was cancelled, keeping partial output", "length", len(content))
+		return content, aiFinishReasonCancelled, nil
+	}
+
+	return content, finishReason, err
+}
+
+// 非流式请求AI接口，返回空的 choices 或5xx时短暂退避后重试，重试用尽后返回最后一次的错误
+func (widget *randomFactWidget) fetchAICompletion(ctx context.Context, headers map[string]string, payload []byte) (string, string, error) {
+	for attempt := 0; ; attempt++ {
+		content, finishReason, err := widget.fetchAICompletionOnce(ctx, headers, payload)
+		if !errors.Is(err, errAIBudgetExceeded) {
+			widget.stats.recordFetch(err)
+		}
+		if err == nil || !isRetryableAIError(err) || attempt >= widget.AIRetries || ctx.Err() != nil {
+			return content, finishReason, aiRequestError(err)
+		}
+
+		widget.logger().Debug("AI request failed, retrying", "attempt", attempt+1, "error", err)
+
+		if err := sleepWithContext(ctx, retryBackoffDelay(attempt)); err != nil {
+			return "", "", err
+		}
+	}
+}
+
+// 发送一次非流式AI请求，每次请求都计入当天的调用次数
+func (widget *randomFactWidget) fetchAICompletionOnce(ctx context.Context, headers map[string]string, payload []byte) (string, string, error) {
+	if !widget.takeAICall() {
+		return "", "", errAIBudgetExceeded
+	}
+
+	requestCtx, cancel := context.WithTimeout(ctx, time.Duration(widget.AITimeout))
+	defer cancel()
+
+	if widget.ResponsePath != defaultAIResponsePath {
+		var body any
+		if err := fetchJSONWithLimit(requestCtx, widget.aiClient, "POST", widget.APIURL, headers, bytes.NewReader(payload), widget.MaxResponseBytes, &body); err != nil {
+			return "", "", err
+		}
+
+		return readAIResponsePath(body, widget.ResponsePath)
+	}
+
+	var aiResp aiResponse
+	if err := fetchJSONWithLimit(requestCtx, widget.aiClient, "POST", widget.APIURL, headers, bytes.NewReader(payload), widget.MaxResponseBytes, &aiResp); err != nil {
+		return "", "", err
+	}
+
+	if aiResp.Error != nil {
+		return "", "", fmt.Errorf("AI API error: %s", aiResp.Error.Message)
+	}
+
+	if len(aiResp.Choices) == 0 {
+		return "", "", errAIEmptyChoices
+	}
+
+	return aiResp.Choices[0].Message.Content, aiResp.Choices[0].FinishReason, nil
+}
+
+// 按 response-path 从解码后的AI响应中读取输出内容
+// 能找到时同时读取标准位置的 finish_reason，路径不存在且 choices 为空时视为空响应
+func readAIResponsePath(body any, path string) (string, string, error) {
+	if message, ok := lookupJSONPath(body, "error.message"); ok {
+		return "", "", fmt.Errorf("AI API error: %v", message)
+	}
+
+	value, ok := lookupJSONPath(body, path)
+	if !ok {
+		if choices, ok := lookupJSONPath(body, "choices"); ok {
+			if choices, ok := choices.([]any); ok && len(choices) == 0 {
+				return "", "", errAIEmptyChoices
+			}
+		}
+		return "", "", fmt.Errorf("AI response has no value at response-path %q", path)
+	}
+
+	content, ok := value.(string)
+	if !ok {
+		return "", "", fmt.Errorf("AI response value at response-path %q is not a string", path)
+	}
+
+	finishReason, _ := lookupJSONPath(body, "choices.0.finish_reason")
+	reason, _ := finishReason.(string)
+
+	return content, reason, nil
+}
+
+// 在解码为 map/slice 的JSON中按点分隔的路径查找值，数字段表示数组下标
+func lookupJSONPath(value any, path string) (any, bool) {
+	for _, segment := range strings.Split(path, ".") {
+		switch current := value.(type) {
+		case map[string]any:
+			next, ok := current[segment]
+			if !ok {
+				return nil, false
+			}
+			value = next
+		case []any:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(current) {
+				return nil, false
+			}
+			value = current[index]
+		default:
+			return nil, false
+		}
+	}
+
+	return value, value != nil
+}
+
+// AI接口返回空的 choices 或5xx时值得重试
+func isRetryableAIError(err error) bool {
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= http.StatusInternalServerError
+	}
+
+	return errors.Is(err, errAIEmptyChoices)
+}
+
+// 非200响应中带有AI接口返回的错误信息时，用该信息代替原始的响应内容
+func aiRequestError(err error) error {
+	var statusErr *httpStatusError
+	if !errors.As(err, &statusErr) {
+		return err
+	}
+
+	var body struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+
+	if json.Unmarshal([]byte(statusErr.Body), &body) == nil && body.Error.Message != "" {
+		return fmt.Errorf("AI API returned status code %d: %s", statusErr.StatusCode, body.Error.Message)
+	}
+
+	return fmt.Errorf("AI API request failed: %w", err)
+}
+
+// 提示词模板中可以使用的数据
+type factPromptData struct {
+	Text string
+}
+
+// 解析系统提示词和用户提示词，设置了 prompt-file 时从文件读取系统提示词
+func (widget *randomFactWidget) loadPrompts() (*texttemplate.Template, *texttemplate.Template, error) {
+	systemPrompt := widget.SystemPrompt
+	if systemPrompt == "" && widget.PromptLanguage != "" {
+		systemPrompt = fmt.Sprintf(factLanguagePromptTemplate, factPromptLanguages[widget.PromptLanguage])
+	}
+	systemPrompt = cmp.Or(systemPrompt, defaultFactSystemPrompt)
+
+	if widget.PromptFile != "" {
+		contents, err := os.ReadFile(widget.PromptFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading prompt-file: %v", err)
+		}
+		systemPrompt = string(contents)
+	}
+
+	systemTemplate, err := parseFactPrompt("system-prompt", systemPrompt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	userTemplate, err := parseFactPrompt("user-prompt", cmp.Or(widget.UserPrompt, defaultFactUserPrompt))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return systemTemplate, userTemplate, nil
+}
+
+// 重新读取 prompt-file 和 facts-file，任一文件读取失败时保留原有内容
+func (widget *randomFactWidget) reloadFiles() error {
+	if widget.PromptFile == "" && widget.FactsFile == "" {
+		return nil
+	}
+
+	systemPrompt, userPrompt, err := widget.loadPrompts()
+	if err != nil {
+		return fmt.Errorf("random-fact: %v", err)
+	}
+
+	var facts []rawFactResponse
+	if widget.FactsFile != "" {
+		if facts, err = loadLocalFacts(widget.FactsFile); err != nil {
+			return fmt.Errorf("random-fact: loading facts-file: %v", err)
+		}
+	}
+
+	widget.mu.Lock()
+	defer widget.mu.Unlock()
+
+	widget.systemPrompt = systemPrompt
+	widget.userPrompt = userPrompt
+	if facts != nil {
+		widget.localFacts = facts
+	}
+
+	widget.logger().Info("Reloaded prompt and facts files")
+
+	return nil
+}
+
+// 标题模板中可以使用的数据
+type factTitleData struct {
+	// 经过AI处理时为 source-label 或模型名称，否则为空
+	Source string
+}
+
+// 解析标题，标题中包含 {{ 时作为模板处理
+func (widget *randomFactWidget) initTitle() error {
+	widget.customTitle = widget.Title != ""
+
+	if strings.Contains(widget.Title, "{{") {
+		tmpl, err := texttemplate.New("title").Parse(widget.Title)
+		if err != nil {
+			return fmt.Errorf("parsing title: %v", err)
+		}
+		widget.titleTmpl = tmpl
+	}
+
+	widget.updateTitle()
+	return nil
+}
+
+// 根据当前的AI来源更新标题，未自定义标题且使用AI时在默认标题后附加来源
+// 实际使用的模型（如改用 cheap-model）只有在更新后才能确定，因此每次更新后都会重新计算
+func (widget *randomFactWidget) updateTitle() {
+	var source string
+	if widget.hasAIConfig() {
+		source = widget.aiSourceLabel(widget.Model)
+		if widget.CachedData != nil && widget.CachedData.Translated {
+			source = widget.CachedData.Source
+		}
+	}
+
+	if widget.titleTmpl != nil {
+		var title strings.Builder
+		if err := widget.titleTmpl.Execute(&title, factTitleData{Source: source}); err != nil {
+			widget.logger().Warn("Failed to render title", "error", err)
+			return
+		}
+		widget.Title = strings.TrimSpace(title.String())
+		return
+	}
+
+	if widget.customTitle {
+		return
+	}
+
+	widget.Title = "Random Fact"
+	if source != "" {
+		widget.Title += " · " + source
+	}
+}
+
+func parseFactPrompt(name string, prompt string) (*texttemplate.Template, error) {
+	tmpl, err := texttemplate.New(name).Parse(prompt)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", name, err)
+	}
+
+	return tmpl, nil
+}
+
+func renderFactPrompt(tmpl *texttemplate.Template, text string) (string, error) {
+	var prompt strings.Builder
+	if err := tmpl.Execute(&prompt, factPromptData{Text: text}); err != nil {
+		return "", fmt.Errorf("rendering %s: %v", tmpl.Name(), err)
+	}
+
+	return prompt.String(), nil
+}
+
+// 当天的AI调用次数，日期变化时重置
+type aiCallBudget struct {
+	day    string
+	calls  int
+	warned bool
+}
+
+// 当天已使用的调用次数是否达到额度的一定比例
+func (widget *randomFactWidget) aiBudgetNearlyExhausted() bool {
+	widget.aiCallsMu.Lock()
+	defer widget.aiCallsMu.Unlock()
+
+	if widget.MaxCallsPerDay <= 0 || widget.aiCalls.day != time.Now().Format(time.DateOnly) {
+		return false
+	}
+
+	return float64(widget.aiCalls.calls) >= float64(widget.MaxCallsPerDay)*cheapModelBudgetThreshold
+}
+
+// 占用一次当天的AI调用额度，额度用尽时返回false并在当天首次用尽时记录日志
+func (widget *randomFactWidget) takeAICall() bool {
+	if widget.MaxCallsPerDay <= 0 {
+		return true
+	}
+
+	widget.aiCallsMu.Lock()
+	defer widget.aiCallsMu.Unlock()
+
+	today := time.Now().Format(time.DateOnly)
+	if widget.aiCalls.day != today {
+		widget.aiCalls = aiCallBudget{day: today}
+	}
+
+	if widget.aiCalls.calls >= widget.MaxCallsPerDay {
+		if !widget.aiCalls.warned {
+			widget.aiCalls.warned = true
+			widget.logger().Warn("Daily AI call budget reached, showing raw facts until midnight", "limit", widget.MaxCallsPerDay)
+		}
+		return false
+	}
+
+	widget.aiCalls.calls++
+
+	return true
+}
+
+// 解析流式AI响应（SSE），拼接各个片段的内容
+func readAIStream(body io.Reader) (string, string, error) {
+	var content strings.Builder
+	var finishReason string
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			return content.String(), finishReason, nil
+		}
+
+		var chunk aiStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return content.String(), finishReason, fmt.Errorf("parsing AI stream chunk: %v", err)
+		}
+
+		if chunk.Error != nil {
+			return content.String(), finishReason, fmt.Errorf("AI API error: %s", chunk.Error.Message)
+		}
+
+		for _, choice := range chunk.Choices {
+			content.WriteString(choice.Delta.Content)
+			if choice.FinishReason != "" {
+				finishReason = choice.FinishReason
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return content.String(), finishReason, err
+	}
+
+	if content.Len() == 0 {
+		return "", "", fmt.Errorf("no content in AI stream")
+	}
+
+	// 部分服务在结束时不发送 [DONE]
+	return content.String(), finishReason, nil
+}
+
+// 经过AI处理的事实展示的来源，设置了 source-label 时使用该标签代替模型名称
+func (widget *randomFactWidget) aiSourceLabel(model string) string {
+	if widget.SourceLabel != "" {
+		return widget.SourceLabel
+	}
+
+	return extractModelName(model)
+}
+
+// 提取模型名称
+func extractModelName(model string) string {
+	// 从模型路径中提取模型名称，如 "Qwen/Qwen3-8B" -> "Qwen3-8B"
+	if len(model) == 0 {
+		return "unknown"
+	}
+
+	// 如果包含斜杠，取最后一部分
+	for i := len(model) - 1; i >= 0; i-- {
+		if model[i] == '/' {
+			return model[i+1:]
+		}
+	}
+
+	return model
+}
+
+// 返回与AI处理结果不同的原文，未经过AI处理时为空
+func (widget *randomFactWidget) OriginalText() string {
+	if widget.CachedData == nil {
+		return ""
+	}
+
+	return widget.CachedData.OriginalText()
+}
+
+// 返回与AI处理结果不同的原文，未经过AI处理时为空
+func (data *randomFactData) OriginalText() string {
+	if data.FactText == data.Content {
+		return ""
+	}
+
+	return data.FactText
+}
+
+// 可以安全渲染为链接的上游来源地址，非 http/https 地址返回空
+func (data *randomFactData) SafeSourceURL() template.URL {
+	return safeURL(data.SourceURL)
+}
+
+// 可以安全渲染为链接的永久链接，非 http/https 地址返回空
+func (data *randomFactData) SafePermalink() template.URL {
+	return safeURL(data.Permalink)
+}
+
+// 渲染Widget，更新或刷新进行中时不等待网络请求，直接返回上一次渲染的内容
+func (widget *randomFactWidget) Render() template.HTML {
+	if !widget.mu.TryLock() {
+		if html := widget.lastRender.Load(); html != nil {
+			return *html
+		}
+		widget.mu.Lock()
+	}
+	defer widget.mu.Unlock()
+
+	return widget.renderLocked()
+}
+
+// 渲染Widget并记录渲染结果，调用方需持有 widget.mu
+func (widget *randomFactWidget) renderLocked() template.HTML {
+	html := widget.renderState()
+	widget.lastRender.Store(&html)
+
+	return html
+}
+
+// 根据当前的事实和错误状态选择渲染的内容
+func (widget *randomFactWidget) renderState() template.HTML {
+	if widget.CachedData == nil {
+		widget.logger().Debug("No cached data available")
+		widget.ContentAvailable = false
+		widget.withError(fmt.Errorf("no data available"))
+		return widget.renderTemplate(nil, mustParseTemplate("widget-base.html"))
+	}
+
+	// 更新失败时继续展示上一次成功获取的事实，超过 max-stale 后才显示错误
+	if widget.Error != nil && widget.isPastMaxStale() {
+		widget.ContentAvailable = false
+		return widget.renderTemplate(widget, randomFactWidgetTemplate)
+	}
+
+	widget.ContentAvailable = true
+	return widget.renderTemplate(widget, randomFactWidgetTemplate)
+}
+
+// 距离上一次成功获取事实是否已超过 max-stale
+func (widget *randomFactWidget) isPastMaxStale() bool {
+	if widget.MaxStaleDuration <= 0 || widget.lastUpdate.IsZero() {
+		return false
+	}
+
+	return time.Since(widget.lastUpdate) > time.Duration(widget.MaxStaleDuration)
+}
+
+// 距离上次获取事实的相对时间，每次渲染时重新计算
+func (widget *randomFactWidget) LastUpdatedRelative() string {
+	return formatRelativeTime(widget.lastUpdate, time.Now(), widget.RelativeLanguage)
+}
+
+// 设置Widget提供者
+func (widget *randomFactWidget) setProviders(providers *widgetProviders) {
+	widget.Providers = providers
+	providers.fileReloaders.register(widget.reloadFiles)
+
+	if !widget.usesOwnTransport() || widget.transportClient != nil {
+		widget.client = widget.httpClient()
+		widget.aiClient = widget.client
+	}
+}
+
+// 替换请求事实API和AI接口使用的传输层，优先于 proxy 和 force-http1 的设置
+func (widget *randomFactWidget) setTransport(transport http.RoundTripper) {
+	widget.widgetBase.setTransport(transport)
+	widget.client = widget.httpClient()
+	widget.aiClient = widget.client
+}
+
+// 设置Widget ID
+func (widget *randomFactWidget) setID(id uint64) {
+	widget.ID = id
+}
+
+// 获取Widget类型
+func (widget *randomFactWidget) GetType() string {
+	return "random-fact"
+}
+
+// 获取Widget ID
+func (widget *randomFactWidget) GetID() uint64 {
+	return widget.ID
+}
+
+// 设置是否隐藏标题
+func (widget *randomFactWidget) setHideHeader(value bool) {
+	widget.HideHeader = value
+}
+
+// 处理HTTP请求，POST 或 ?refresh=1 时立即获取新的事实
+// 默认返回渲染后的HTML片段，?format=json 时返回JSON状态
+func (widget *randomFactWidget) handleRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if r.Method == http.MethodPost || r.URL.Query().Get("refresh") == "1" {
+		widget.refresh(r.Context())
+	}
+
+	widget.mu.Lock()
+	defer widget.mu.Unlock()
+
+	if r.URL.Query().Get("format") == "json" {
+		widget.writeJSONStatus(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(widget.renderLocked()))
+}
+
+// 正在更新或刷新时无需再次更新，页面渲染不必等待进行中的网络请求
+func (widget *randomFactWidget) requiresUpdate(now *time.Time) bool {
+	if !widget.mu.TryLock() {
+		return false
+	}
+	defer widget.mu.Unlock()
+
+	return widget.widgetBase.requiresUpdate(now)
+}
+
+// 强制刷新事实，已有刷新进行中时不会重复请求
+func (widget *randomFactWidget) refresh(ctx context.Context) {
+	if !widget.refreshing.CompareAndSwap(false, true) {
+		return
+	}
+	defer widget.refreshing.Store(false)
+
+	widget.mu.Lock()
+	defer widget.mu.Unlock()
+
+	// 不清空缓存和更新时间，刷新失败时仍展示上一条事实并保留其真实的获取时间
+	widget.updateLocked(ctx, true)
+}
+
+// 输出当前事实和错误状态的JSON
+func (widget *randomFactWidget) writeJSONStatus(w http.ResponseWriter) {
+	status := struct {
+		OK    bool              `json:"ok"`
+		Error string            `json:"error,omitempty"`
+		Fact  *randomFactData   `json:"fact,omitempty"`
+		Facts []*randomFactData `json:"facts,omitempty"`
+	}{
+		OK:    widget.Error == nil && widget.CachedData != nil,
+		Fact:  widget.CachedData,
+		Facts: widget.CachedFacts,
+	}
+
+	if widget.Error != nil {
+		status.Error = widget.Error.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !status.OK {
+		w.WriteHeader(http.StatusBadGateway)
+	}
+
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,1452 @@
+package glance
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestFactServer(t *testing.T, facts []rawFactResponse) *httptest.Server {
+	t.Helper()
+
+	var served atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i := int(served.Add(1)-1) % len(facts)
+		json.NewEncoder(w).Encode(facts[i])
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// newTestStaticServer returns a server that replies to every request with the given status and body
+func newTestStaticServer(t *testing.T, status int, contentType string, body string) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// newTestAIServer returns an OpenAI-compatible server that replies with the given contents in order
+func newTestAIServer(t *testing.T, contents ...string) *httptest.Server {
+	t.Helper()
+
+	var served atomic.Int32
+	return newTestAIServerFunc(t, func(payload map[string]any) (string, string) {
+		return contents[min(int(served.Add(1)-1), len(contents)-1)], "stop"
+	})
+}
+
+// newTestAIServerFunc returns an OpenAI-compatible server that replies with
+// the content and finish reason returned by respond for each decoded request payload
+func newTestAIServerFunc(t *testing.T, respond func(payload map[string]any) (string, string)) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		content, finishReason := respond(payload)
+		response := map[string]any{
+			"choices": []map[string]any{
+				{
+					"message":       map[string]string{"content": content},
+					"finish_reason": finishReason,
+				},
+			},
+		}
+
+		json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func withTestAIServer(widget *randomFactWidget, server *httptest.Server) *randomFactWidget {
+	widget.APIKey = "test-key"
+	widget.Model = "test/model"
+	widget.APIURL = server.URL
+
+	return widget
+}
+
+func newTestRandomFactWidget(t *testing.T, widget *randomFactWidget, factURL string) *randomFactWidget {
+	t.Helper()
+
+	widget.factURL = factURL
+	if err := widget.initialize(); err != nil {
+		t.Fatalf("Failed to initialize random fact widget: %v", err)
+	}
+
+	return widget
+}
+
+func TestRandomFactDedupeByText(t *testing.T) {
+	facts := []rawFactResponse{
+		{ID: "1", Text: "Penguins have knees."},
+		{ID: "2", Text: "penguins  HAVE knees"},
+		{ID: "3", Text: "Honey never spoils."},
+	}
+
+	tests := []struct {
+		dedupeBy string
+		expected string
+	}{
+		{factDedupeByID, "2"},
+		{factDedupeByText, "3"},
+		{factDedupeByIDAndText, "3"},
+	}
+
+	for _, test := range tests {
+		server := newTestFactServer(t, facts)
+		widget := newTestRandomFactWidget(t, &randomFactWidget{DedupeBy: test.dedupeBy}, server.URL)
+
+		first, err := widget.fetchUniqueFact(context.Background())
+		if err != nil {
+			t.Fatalf("%s: failed to fetch first fact: %v", test.dedupeBy, err)
+		}
+		if first.ID != "1" {
+			t.Fatalf("%s: expected first fact to have ID 1, got %s", test.dedupeBy, first.ID)
+		}
+
+		second, err := widget.fetchUniqueFact(context.Background())
+		if err != nil {
+			t.Fatalf("%s: failed to fetch second fact: %v", test.dedupeBy, err)
+		}
+		if second.ID != test.expected {
+			t.Errorf("%s: expected second fact to have ID %s, got %s", test.dedupeBy, test.expected, second.ID)
+		}
+	}
+}
+
+func TestRandomFactInvalidDedupeBy(t *testing.T) {
+	widget := &randomFactWidget{DedupeBy: "hash"}
+	if err := widget.initialize(); err == nil {
+		t.Fatal("Expected an error for an invalid dedupe-by value")
+	}
+}
+
+func TestRandomFactAccentColor(t *testing.T) {
+	widget := &randomFactWidget{AccentColor: "#ff8800"}
+	if err := widget.initialize(); err != nil {
+		t.Fatalf("Failed to initialize widget with a valid accent color: %v", err)
+	}
+
+	widget.CachedData = &randomFactData{FactID: "1", FactText: "fact", Content: "fact"}
+
+	html := string(widget.Render())
+	if !strings.Contains(html, "--fact-accent-color: #ff8800") {
+		t.Errorf("Expected accent color to be passed to the template, got: %s", html)
+	}
+
+	for _, color := range []string{"red", "#ff88", "ff8800", "#ff8800; background: red"} {
+		widget := &randomFactWidget{AccentColor: color}
+		if err := widget.initialize(); err == nil {
+			t.Errorf("Expected accent color %q to be rejected", color)
+		}
+	}
+}
+
+func TestRandomFactShowOriginal(t *testing.T) {
+	first := &randomFactData{FactID: "1", FactText: "Honey never spoils.", Content: "蜂蜜永远不会变质。", Translation: "蜂蜜永远不会变质。"}
+	second := &randomFactData{FactID: "2", FactText: "Sloths sleep a lot.", Content: "树懒很能睡。", Translation: "树懒很能睡。"}
+
+	for _, facts := range [][]*randomFactData{{first}, {first, second}} {
+		for _, showOriginal := range []bool{false, true} {
+			widget := &randomFactWidget{ShowOriginal: showOriginal}
+			if err := widget.initialize(); err != nil {
+				t.Fatalf("Failed to initialize widget: %v", err)
+			}
+			widget.CachedData = facts[0]
+			widget.CachedFacts = facts
+
+			html := string(widget.Render())
+			if shown := strings.Contains(html, first.FactText); shown != showOriginal {
+				t.Errorf("%d facts with show-original %v: original shown was %v", len(facts), showOriginal, shown)
+			}
+			if showOriginal && strings.Index(html, first.FactText) < strings.Index(html, first.Translation) {
+				t.Errorf("%d facts: expected the original below the translation", len(facts))
+			}
+		}
+	}
+}
+
+func TestRandomFactGlossary(t *testing.T) {
+	factServer := newTestFactServer(t, []rawFactResponse{{ID: "1", Text: "PEZ candy even comes in coffee flavor."}})
+	aiServer := newTestAIServer(t, "Pez糖果甚至有咖啡味的。\nPez是一个糖果品牌，Pezzy不是。")
+
+	widget := withTestAIServer(&randomFactWidget{
+		Glossary: map[string]string{
+			"Pez": "PEZ",
+			"咖啡味": "咖啡口味",
+		},
+	}, aiServer)
+	newTestRandomFactWidget(t, widget, factServer.URL)
+
+	widget.update(context.Background())
+
+	if widget.CachedData == nil {
+		t.Fatal("Expected cached data after update")
+	}
+
+	expected := "PEZ糖果甚至有咖啡口味的。\nPEZ是一个糖果品牌，Pezzy不是。"
+	if widget.CachedData.Content != expected {
+		t.Errorf("Expected glossary to be applied:\nexpected: %q\ngot:      %q", expected, widget.CachedData.Content)
+	}
+}
+
+func TestRandomFactTruncatedAIOutput(t *testing.T) {
+	factServer := newTestFactServer(t, []rawFactResponse{{ID: "1", Text: "PEZ candy even comes in coffee flavor."}})
+
+	for _, retry := range []bool{false, true} {
+		var requests atomic.Int32
+		aiServer := newTestAIServerFunc(t, func(payload map[string]any) (string, string) {
+			requests.Add(1)
+			if payload["max_tokens"].(float64) < aiRetryMaxTokens {
+				return "PEZ糖果甚至有 ", aiFinishReasonLength
+			}
+			return "PEZ糖果甚至有咖啡味的。", "stop"
+		})
+
+		widget := withTestAIServer(&randomFactWidget{RetryTruncated: retry}, aiServer)
+		newTestRandomFactWidget(t, widget, factServer.URL)
+		widget.update(context.Background())
+
+		expectedContent, expectedRequests := "PEZ糖果甚至有…", int32(1)
+		if retry {
+			expectedContent, expectedRequests = "PEZ糖果甚至有咖啡味的。", 2
+		}
+
+		if widget.CachedData.Content != expectedContent {
+			t.Errorf("retry=%t: expected content %q, got %q", retry, expectedContent, widget.CachedData.Content)
+		}
+
+		if requests.Load() != expectedRequests {
+			t.Errorf("retry=%t: expected %d AI requests, got %d", retry, expectedRequests, requests.Load())
+		}
+	}
+}
+
+type recordingFactCache struct {
+	name    string
+	values  map[string]string
+	lookups *[]string
+}
+
+func (c *recordingFactCache) Get(key string) (string, bool) {
+	*c.lookups = append(*c.lookups, c.name)
+	value, ok := c.values[key]
+	return value, ok
+}
+
+func (c *recordingFactCache) Set(key string, value string) {
+	c.values[key] = value
+}
+
+func TestTieredFactCacheLookupOrderAndBackfill(t *testing.T) {
+	var lookups []string
+	memory := &recordingFactCache{name: "memory", values: map[string]string{}, lookups: &lookups}
+	disk := &recordingFactCache{name: "disk", values: map[string]string{}, lookups: &lookups}
+	remote := &recordingFactCache{name: "remote", values: map[string]string{"1": "remote value"}, lookups: &lookups}
+
+	cache := tieredFactCache{tiers: []factCache{memory, disk, remote}}
+
+	value, ok := cache.Get("1")
+	if !ok || value != "remote value" {
+		t.Fatalf("Expected remote value, got %q (found: %t)", value, ok)
+	}
+
+	if !slices.Equal(lookups, []string{"memory", "disk", "remote"}) {
+		t.Errorf("Expected tiers to be looked up in order, got %v", lookups)
+	}
+
+	if memory.values["1"] != "remote value" || disk.values["1"] != "remote value" {
+		t.Error("Expected upper tiers to be backfilled on a remote hit")
+	}
+
+	lookups = nil
+	if _, ok := cache.Get("1"); !ok {
+		t.Fatal("Expected a hit after backfill")
+	}
+
+	if !slices.Equal(lookups, []string{"memory"}) {
+		t.Errorf("Expected lookup to stop at the memory tier, got %v", lookups)
+	}
+
+	lookups = nil
+	if _, ok := cache.Get("2"); ok {
+		t.Error("Expected a miss for an unknown key")
+	}
+
+	cache.Set("2", "new value")
+	for _, tier := range []*recordingFactCache{memory, disk, remote} {
+		if tier.values["2"] != "new value" {
+			t.Errorf("Expected %s tier to be populated on set", tier.name)
+		}
+	}
+}
+
+func TestDiskFactCachePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "translations.json")
+
+	first := &diskFactCache{path: path, cache: factTranslationCache{size: 10}}
+	first.Set("1", "translated")
+
+	second := &diskFactCache{path: path, cache: factTranslationCache{size: 10}}
+	if value, ok := second.Get("1"); !ok || value != "translated" {
+		t.Errorf("Expected value to be loaded from disk, got %q (found: %t)", value, ok)
+	}
+}
+
+func TestRandomFactEnforceLength(t *testing.T) {
+	const valid = "企鹅其实是有膝盖的，只是看不出来。\n它们的膝盖隐藏在厚厚的羽毛和身体结构中。"
+
+	tests := []struct {
+		name    string
+		initial string
+	}{
+		{"too short", "企鹅有膝盖。\n真的。"},
+		{"too long", "企鹅其实是有膝盖的。\n" + strings.Repeat("它们的膝盖隐藏在厚厚的羽毛中", 6)},
+	}
+
+	factServer := newTestFactServer(t, []rawFactResponse{{ID: "1", Text: "Penguins have knees."}})
+
+	for _, test := range tests {
+		var retried atomic.Bool
+		aiServer := newTestAIServerFunc(t, func(payload map[string]any) (string, string) {
+			messages := payload["messages"].([]any)
+			for _, message := range messages {
+				if message.(map[string]any)["content"] == aiLengthRetryInstruction {
+					retried.Store(true)
+					return valid, "stop"
+				}
+			}
+			return test.initial, "stop"
+		})
+
+		widget := withTestAIServer(&randomFactWidget{EnforceLength: true}, aiServer)
+		newTestRandomFactWidget(t, widget, factServer.URL)
+		widget.update(context.Background())
+
+		if !retried.Load() {
+			t.Errorf("%s: expected a retry with the stricter instruction", test.name)
+		}
+
+		if widget.CachedData.Content != valid {
+			t.Errorf("%s: expected retried content to be used, got %q", test.name, widget.CachedData.Content)
+		}
+	}
+}
+
+func TestRandomFactShowHistory(t *testing.T) {
+	var facts []rawFactResponse
+	for i := range 5 {
+		facts = append(facts, rawFactResponse{ID: strconv.Itoa(i + 1), Text: "Fact number " + strconv.Itoa(i+1)})
+	}
+
+	server := newTestFactServer(t, facts)
+	widget := newTestRandomFactWidget(t, &randomFactWidget{ShowHistory: 3}, server.URL)
+
+	expected := [][]string{
+		{"1"},
+		{"2", "1"},
+		{"3", "2", "1"},
+		{"4", "3", "2"},
+		{"5", "4", "3"},
+	}
+
+	for i, ids := range expected {
+		widget.lastUpdate = time.Time{}
+		widget.update(context.Background())
+
+		var got []string
+		for _, fact := range widget.DisplayedFacts() {
+			got = append(got, fact.FactID)
+		}
+
+		if !slices.Equal(got, ids) {
+			t.Errorf("Update %d: expected history %v, got %v", i+1, ids, got)
+		}
+	}
+}
+
+// cancelOnReadBody cancels the request context as soon as the first bytes of the response body are read
+type cancelOnReadBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnReadBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.cancel()
+	}
+
+	return n, err
+}
+
+type cancelOnReadTransport struct {
+	cancel context.CancelFunc
+}
+
+func (t *cancelOnReadTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Body = &cancelOnReadBody{ReadCloser: resp.Body, cancel: t.cancel}
+
+	return resp, nil
+}
+
+func TestRandomFactKeepPartialOnCancel(t *testing.T) {
+	factServer := newTestFactServer(t, []rawFactResponse{{ID: "1", Text: "Bananas are berries."}})
+
+	// 发送一个片段后一直等待，直到客户端断开连接
+	aiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(`data: {"choices":[{"delta":{"content":"香蕉属于浆果"}}]}` + "\n\n"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	t.Cleanup(aiServer.Close)
+
+	keep, discard := true, false
+	tests := []struct {
+		name     string
+		raw      *bool
+		expected string
+	}{
+		{"default", nil, "香蕉属于浆果…"},
+		{"keep", &keep, "香蕉属于浆果…"},
+		{"discard", &discard, "Bananas are berries."},
+	}
+
+	for _, test := range tests {
+		widget := withTestAIServer(&randomFactWidget{Stream: true, KeepPartialRaw: test.raw}, aiServer)
+		newTestRandomFactWidget(t, widget, factServer.URL)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		widget.aiClient = &http.Client{Transport: &cancelOnReadTransport{cancel: cancel}}
+		widget.update(ctx)
+		cancel()
+
+		if widget.CachedData.Content != test.expected {
+			t.Errorf("%s: expected content %q, got %q", test.name, test.expected, widget.CachedData.Content)
+		}
+
+		if widget.CachedData.Translated {
+			t.Errorf("%s: partial output should not be marked as translated", test.name)
+		}
+
+		if _, ok := widget.translations.Get("1"); ok {
+			t.Errorf("%s: partial output should not be cached", test.name)
+		}
+	}
+}
+
+func TestRandomFactPromptTemplate(t *testing.T) {
+	widget := &randomFactWidget{UserPrompt: "翻译：{{ .Text "}
+	if err := widget.initialize(); err == nil || !strings.Contains(err.Error(), "user-prompt") {
+		t.Fatalf("Expected malformed user-prompt to fail at initialize, got %v", err)
+	}
+
+	factServer := newTestFactServer(t, []rawFactResponse{{ID: "1", Text: "Cats sleep a lot."}})
+
+	var userContent string
+	aiServer := newTestAIServerFunc(t, func(payload map[string]any) (string, string) {
+		messages := payload["messages"].([]any)
+		userContent = messages[len(messages)-1].(map[string]any)["content"].(string)
+		return "猫睡得很多。", "stop"
+	})
+
+	widget = withTestAIServer(&randomFactWidget{UserPrompt: "翻译：{{ .Text }}"}, aiServer)
+	newTestRandomFactWidget(t, widget, factServer.URL)
+	widget.update(context.Background())
+
+	if userContent != "翻译：Cats sleep a lot." {
+		t.Errorf("Expected rendered user prompt, got %q", userContent)
+	}
+}
+
+func TestRandomFactCheapModelNearBudget(t *testing.T) {
+	factServer := newTestFactServer(t, []rawFactResponse{{ID: "1", Text: "Octopuses have three hearts."}})
+
+	var models []string
+	aiServer := newTestAIServerFunc(t, func(payload map[string]any) (string, string) {
+		models = append(models, payload["model"].(string))
+		return "章鱼有三颗心脏。", "stop"
+	})
+
+	tests := []struct {
+		usedCalls      int
+		expectedModel  string
+		expectedSource string
+	}{
+		{0, "test/model", "model"},
+		{8, "test/cheap", "cheap"},
+	}
+
+	for _, test := range tests {
+		models = nil
+
+		widget := withTestAIServer(&randomFactWidget{MaxCallsPerDay: 10, CheapModel: "test/cheap"}, aiServer)
+		newTestRandomFactWidget(t, widget, factServer.URL)
+		widget.aiCalls = aiCallBudget{day: time.Now().Format(time.DateOnly), calls: test.usedCalls}
+		widget.update(context.Background())
+
+		if !slices.Equal(models, []string{test.expectedModel}) {
+			t.Errorf("%d used calls: expected requests with model %s, got %v", test.usedCalls, test.expectedModel, models)
+		}
+
+		if widget.CachedData.Source != test.expectedSource {
+			t.Errorf("%d used calls: expected source %q, got %q", test.usedCalls, test.expectedSource, widget.CachedData.Source)
+		}
+	}
+}
+
+func TestRandomFactSelfCheck(t *testing.T) {
+	factServer := newTestFactServer(t, []rawFactResponse{{ID: "1", Text: "A group of flamingos is called a flamboyance."}})
+
+	for _, selfCheck := range []bool{false, true} {
+		var requests []string
+		aiServer := newTestAIServerFunc(t, func(payload map[string]any) (string, string) {
+			messages := payload["messages"].([]any)
+			requests = append(requests, messages[len(messages)-1].(map[string]any)["content"].(string))
+
+			if len(requests) == 1 {
+				return "一群火烈鸟被称为一场炫耀。\n火烈鸟喜欢成群活动。", "stop"
+			}
+			return "一群火烈鸟被称为“flamboyance”。\n这个英文集合名词意为艳丽。", "stop"
+		})
+
+		widget := withTestAIServer(&randomFactWidget{SelfCheck: selfCheck}, aiServer)
+		newTestRandomFactWidget(t, widget, factServer.URL)
+		widget.update(context.Background())
+
+		expectedTranslation, expectedRequests := "一群火烈鸟被称为一场炫耀。", 1
+		if selfCheck {
+			expectedTranslation, expectedRequests = "一群火烈鸟被称为“flamboyance”。", 2
+		}
+
+		if widget.CachedData.Translation != expectedTranslation {
+			t.Errorf("self-check=%t: expected translation %q, got %q", selfCheck, expectedTranslation, widget.CachedData.Translation)
+		}
+
+		if len(requests) != expectedRequests {
+			t.Fatalf("self-check=%t: expected %d AI requests, got %d", selfCheck, expectedRequests, len(requests))
+		}
+
+		if selfCheck && !strings.Contains(requests[1], "一群火烈鸟被称为一场炫耀。") {
+			t.Errorf("Expected self-check request to include the first translation, got %q", requests[1])
+		}
+	}
+}
+
+func TestRandomFactJSONExportProvenance(t *testing.T) {
+	factServer := newTestFactServer(t, []rawFactResponse{{
+		ID:        "abc123",
+		Text:      "Sloths can hold their breath longer than dolphins.",
+		Source:    "djtech.net",
+		SourceURL: "http://www.djtech.net/humor/useless_facts.htm",
+		Language:  "en",
+		Permalink: "https://uselessfacts.jsph.pl/api/v2/facts/abc123",
+	}})
+
+	widget := newTestRandomFactWidget(t, &randomFactWidget{}, factServer.URL)
+	widget.update(context.Background())
+
+	recorder := httptest.NewRecorder()
+	widget.handleRequest(recorder, httptest.NewRequest(http.MethodGet, "/?format=json", nil))
+
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Fatalf("Expected JSON content type, got %s", contentType)
+	}
+
+	var export struct {
+		Fact map[string]any `json:"fact"`
+	}
+	if err := json.NewDecoder(recorder.Body).Decode(&export); err != nil {
+		t.Fatalf("Failed to decode JSON export: %v", err)
+	}
+
+	expected := map[string]string{
+		"fact_id":    "abc123",
+		"permalink":  "https://uselessfacts.jsph.pl/api/v2/facts/abc123",
+		"language":   "en",
+		"source_url": "http://www.djtech.net/humor/useless_facts.htm",
+	}
+
+	for field, value := range expected {
+		if export.Fact[field] != value {
+			t.Errorf("Expected %s to be %q in JSON export, got %v", field, value, export.Fact[field])
+		}
+	}
+}
+
+func TestRandomFactForceHTTP1(t *testing.T) {
+	widget := newTestRandomFactWidget(t, &randomFactWidget{}, "")
+	if widget.client != sharedHTTPClient {
+		t.Error("Expected the shared HTTP client to be used by default")
+	}
+
+	widget = newTestRandomFactWidget(t, &randomFactWidget{ForceHTTP1: true}, "")
+
+	for name, client := range map[string]*http.Client{"fact": widget.client, "AI": widget.aiClient} {
+		transport, ok := client.Transport.(*http.Transport)
+		if !ok {
+			t.Fatalf("Expected %s client to use an *http.Transport, got %T", name, client.Transport)
+		}
+
+		if transport.ForceAttemptHTTP2 {
+			t.Errorf("Expected %s transport not to attempt HTTP/2", name)
+		}
+
+		if transport.TLSNextProto == nil || len(transport.TLSNextProto) != 0 {
+			t.Errorf("Expected %s transport to have an empty non-nil TLSNextProto map", name)
+		}
+	}
+}
+
+// headerRecordingTransport records the value of a request header before passing the request on
+type headerRecordingTransport struct {
+	header string
+	values []string
+}
+
+func (t *headerRecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.values = append(t.values, req.Header.Get(t.header))
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestRandomFactIdempotencyKey(t *testing.T) {
+	factServer := newTestFactServer(t, []rawFactResponse{
+		{ID: "1", Text: "Honey never spoils."},
+		{ID: "2", Text: "Bananas are berries."},
+	})
+
+	// 每条事实的第一次请求都被截断，触发一次重试
+	aiServer := newTestAIServerFunc(t, func(payload map[string]any) (string, string) {
+		if payload["max_tokens"].(float64) < aiRetryMaxTokens {
+			return "被截断的", aiFinishReasonLength
+		}
+		return "完整的翻译。", "stop"
+	})
+
+	// 按顺序处理，便于按请求顺序检查幂等键
+	widget := withTestAIServer(&randomFactWidget{Count: 2, AIConcurrency: 1, RetryTruncated: true, Idempotency: true}, aiServer)
+	newTestRandomFactWidget(t, widget, factServer.URL)
+
+	recorder := &headerRecordingTransport{header: "Idempotency-Key"}
+	widget.aiClient = &http.Client{Transport: recorder}
+	widget.update(context.Background())
+
+	keys := recorder.values
+	if len(keys) != 4 {
+		t.Fatalf("Expected 4 AI requests, got %d", len(keys))
+	}
+
+	if keys[0] == "" || keys[2] == "" {
+		t.Fatalf("Expected Idempotency-Key header to be sent, got %v", keys)
+	}
+
+	if keys[0] != keys[1] || keys[2] != keys[3] {
+		t.Errorf("Expected retries to reuse the same key, got %v", keys)
+	}
+
+	if keys[0] == keys[2] {
+		t.Errorf("Expected different facts to use different keys, got %v", keys)
+	}
+}
+
+func TestRandomFactReloadPromptFile(t *testing.T) {
+	promptFile := filepath.Join(t.TempDir(), "prompt.txt")
+	if err := os.WriteFile(promptFile, []byte("旧的提示词"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	factServer := newTestFactServer(t, []rawFactResponse{
+		{ID: "1", Text: "Honey never spoils."},
+		{ID: "2", Text: "Bananas are berries."},
+	})
+
+	var systemContent string
+	aiServer := newTestAIServerFunc(t, func(payload map[string]any) (string, string) {
+		messages := payload["messages"].([]any)
+		systemContent = messages[0].(map[string]any)["content"].(string)
+		return "翻译。", "stop"
+	})
+
+	widget := withTestAIServer(&randomFactWidget{PromptFile: promptFile}, aiServer)
+	newTestRandomFactWidget(t, widget, factServer.URL)
+
+	reloaders := &fileReloaders{}
+	widget.setProviders(&widgetProviders{fileReloaders: reloaders})
+
+	widget.update(context.Background())
+	if systemContent != "旧的提示词" {
+		t.Fatalf("Expected prompt from file, got %q", systemContent)
+	}
+
+	if err := os.WriteFile(promptFile, []byte("新的提示词：{{ .Text"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if errs := reloaders.reload(); len(errs) != 1 {
+		t.Fatalf("Expected malformed prompt to fail reloading, got %v", errs)
+	}
+
+	if prompt, _ := renderFactPrompt(widget.systemPrompt, ""); prompt != "旧的提示词" {
+		t.Fatalf("Expected failed reload to keep the previous prompt, got %q", prompt)
+	}
+
+	if err := os.WriteFile(promptFile, []byte("新的提示词"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if errs := reloaders.reload(); len(errs) != 0 {
+		t.Fatalf("Expected reload to succeed, got %v", errs)
+	}
+
+	// 让缓存过期以获取下一条事实
+	widget.lastUpdate = time.Time{}
+	widget.update(context.Background())
+	if systemContent != "新的提示词" {
+		t.Errorf("Expected reloaded prompt to be used, got %q", systemContent)
+	}
+}
+
+func TestRandomFactRuby(t *testing.T) {
+	if err := (&randomFactWidget{Ruby: true}).initialize(); err == nil {
+		t.Error("Expected ruby without output-language ja to be rejected")
+	}
+
+	factServer := newTestFactServer(t, []rawFactResponse{{ID: "1", Text: "Cats sleep a lot."}})
+
+	var rubyRequested bool
+	aiServer := newTestAIServerFunc(t, func(payload map[string]any) (string, string) {
+		for _, message := range payload["messages"].([]any) {
+			if message.(map[string]any)["content"] == aiRubyInstruction {
+				rubyRequested = true
+			}
+		}
+		return "{猫|ねこ}はよく<b>寝る</b>。\n{一日|いちにち}の半分以上を寝て過ごす。", "stop"
+	})
+
+	widget := withTestAIServer(&randomFactWidget{OutputLanguage: "ja", Ruby: true}, aiServer)
+	newTestRandomFactWidget(t, widget, factServer.URL)
+	widget.update(context.Background())
+
+	if !rubyRequested {
+		t.Error("Expected ruby annotations to be requested from the model")
+	}
+
+	html := string(widget.Render())
+	for _, expected := range []string{
+		`<ruby>猫<rp>(</rp><rt>ねこ</rt><rp>)</rp></ruby>はよく&lt;b&gt;寝る&lt;/b&gt;。`,
+		`<ruby>一日<rp>(</rp><rt>いちにち</rt><rp>)</rp></ruby>の半分以上`,
+		`lang="ja"`,
+	} {
+		if !strings.Contains(html, expected) {
+			t.Errorf("Expected rendered html to contain %q", expected)
+		}
+	}
+
+	if got := widget.FormatText("ふつうの文"); got != "ふつうの文" {
+		t.Errorf("Expected text without annotations to be rendered as is, got %q", got)
+	}
+}
+
+func TestRandomFactSafeURL(t *testing.T) {
+	tests := map[string]template.URL{
+		"https://uselessfacts.jsph.pl/api/v2/facts/abc123": "https://uselessfacts.jsph.pl/api/v2/facts/abc123",
+		" http://www.djtech.net/humor/ ":                   "http://www.djtech.net/humor/",
+		"HTTPS://example.com":                              "HTTPS://example.com",
+		"javascript:alert(1)":                              "",
+		"JavaScript:alert(document.cookie)":                "",
+		" javascript:alert(1)":                             "",
+		"data:text/html,<script>alert(1)</script>":         "",
+		"vbscript:msgbox(1)":                               "",
+		"//evil.example.com/path":                          "",
+		"/relative/path":                                   "",
+		"https:///no-host":                                 "",
+		"":                                                 "",
+	}
+
+	for raw, expected := range tests {
+		if actual := safeURL(raw); actual != expected {
+			t.Errorf("Expected safeURL(%q) to be %q, got %q", raw, expected, actual)
+		}
+	}
+
+	factServer := newTestFactServer(t, []rawFactResponse{{
+		ID:        "abc123",
+		Text:      "Sloths can hold their breath longer than dolphins.",
+		Source:    "djtech.net",
+		SourceURL: "javascript:alert(1)",
+	}})
+
+	widget := newTestRandomFactWidget(t, &randomFactWidget{}, factServer.URL)
+	widget.update(context.Background())
+
+	html := string(widget.Render())
+	if strings.Contains(html, "javascript:") {
+		t.Error("Expected javascript: source URL not to be rendered")
+	}
+
+	if !strings.Contains(html, widget.CachedData.Source) {
+		t.Error("Expected source name to still be rendered without a link")
+	}
+}
+
+func TestRandomFactAIRetries(t *testing.T) {
+	factServer := newTestFactServer(t, []rawFactResponse{{ID: "1", Text: "Honey never spoils."}})
+
+	// 第一次返回空的 choices，之后正常返回
+	var requests atomic.Int32
+	aiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.Write([]byte(`{"choices":[]}`))
+			return
+		}
+		w.Write([]byte(`{"choices":[{"message":{"content":"蜂蜜永远不会变质。"},"finish_reason":"stop"}]}`))
+	}))
+	t.Cleanup(aiServer.Close)
+
+	widget := newTestRandomFactWidget(t, withTestAIServer(&randomFactWidget{}, aiServer), factServer.URL)
+	widget.update(context.Background())
+
+	if requests.Load() != 2 {
+		t.Fatalf("Expected empty choices to be retried once, got %d requests", requests.Load())
+	}
+
+	if widget.CachedData.Content != "蜂蜜永远不会变质。" {
+		t.Errorf("Expected retried AI output to be used, got %q", widget.CachedData.Content)
+	}
+
+	// 持续返回5xx时重试用尽后回退到原文
+	requests.Store(0)
+	failingServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.Error(w, "upstream unavailable", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(failingServer.Close)
+
+	widget = newTestRandomFactWidget(t, withTestAIServer(&randomFactWidget{}, failingServer), factServer.URL)
+	widget.update(context.Background())
+
+	if requests.Load() != 2 {
+		t.Fatalf("Expected 5xx to be retried once, got %d requests", requests.Load())
+	}
+
+	if widget.CachedData.Content != "Honey never spoils." {
+		t.Errorf("Expected raw fact after exhausting retries, got %q", widget.CachedData.Content)
+	}
+}
+
+func TestRandomFactStats(t *testing.T) {
+	factServer := newTestFactServer(t, []rawFactResponse{{ID: "1", Text: "Honey never spoils."}})
+	aiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}))
+	t.Cleanup(aiServer.Close)
+
+	widget := newTestRandomFactWidget(t, withTestAIServer(&randomFactWidget{}, aiServer), factServer.URL)
+	widget.update(context.Background())
+	widget.update(context.Background())
+
+	expected := widgetStatsSnapshot{Fetches: 2, CacheHits: 1, APIErrors: 1, AIFallbacks: 1}
+	if stats := widget.Stats(); stats != expected {
+		t.Errorf("Expected stats %+v, got %+v", expected, stats)
+	}
+}
+
+func TestRandomFactBuiltInFacts(t *testing.T) {
+	if len(builtInFacts) == 0 {
+		t.Fatal("Expected built-in facts to be embedded")
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}))
+	t.Cleanup(server.Close)
+
+	for _, source := range []string{factSourceRemote, factSourceLocal} {
+		widget := newTestRandomFactWidget(t, &randomFactWidget{Source: source}, server.URL)
+		widget.update(context.Background())
+
+		if widget.Error != nil || widget.CachedData == nil {
+			t.Fatalf("Expected %s source to fall back to built-in facts, got error %v", source, widget.Error)
+		}
+
+		if widget.CachedData.Source != factSourceBuiltIn {
+			t.Errorf("Expected %s source to show a built-in fact, got source %q", source, widget.CachedData.Source)
+		}
+	}
+}
+
+func TestRandomFactAIDisabled(t *testing.T) {
+	factServer := newTestFactServer(t, []rawFactResponse{{ID: "1", Text: "Honey never spoils."}})
+
+	var requests atomic.Int32
+	aiServer := newTestAIServerFunc(t, func(payload map[string]any) (string, string) {
+		requests.Add(1)
+		return "蜂蜜永远不会变质。", "stop"
+	})
+
+	disabled := false
+	widget := newTestRandomFactWidget(t, withTestAIServer(&randomFactWidget{AIEnabled: &disabled}, aiServer), factServer.URL)
+	widget.update(context.Background())
+
+	if requests.Load() != 0 {
+		t.Errorf("Expected no AI requests when ai-enabled is false, got %d", requests.Load())
+	}
+
+	if widget.CachedData.Content != "Honey never spoils." {
+		t.Errorf("Expected raw fact text, got %q", widget.CachedData.Content)
+	}
+}
+
+func TestRandomFactTitle(t *testing.T) {
+	factServer := newTestFactServer(t, []rawFactResponse{{ID: "1", Text: "Honey never spoils."}})
+	aiServer := newTestAIServer(t, "蜂蜜永远不会变质。")
+
+	tests := []struct {
+		widget   *randomFactWidget
+		expected string
+	}{
+		{&randomFactWidget{}, "Random Fact"},
+		{withTestAIServer(&randomFactWidget{}, aiServer), "Random Fact · model"},
+		{withTestAIServer(&randomFactWidget{SourceLabel: "my-llm"}, aiServer), "Random Fact · my-llm"},
+		{withTestAIServer(&randomFactWidget{widgetBase: widgetBase{Title: "Facts"}}, aiServer), "Facts"},
+		{withTestAIServer(&randomFactWidget{widgetBase: widgetBase{Title: "Facts{{ with .Source }} ({{ . }}){{ end }}"}}, aiServer), "Facts (model)"},
+	}
+
+	for _, test := range tests {
+		widget := newTestRandomFactWidget(t, test.widget, factServer.URL)
+		widget.update(context.Background())
+
+		if widget.Title != test.expected {
+			t.Errorf("Expected title %q, got %q", test.expected, widget.Title)
+		}
+	}
+}
+
+func TestRandomFactDecodeErrorIncludesBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+		w.Write([]byte("<!DOCTYPE html>\n<title>Just a moment...</title>"))
+	}))
+	t.Cleanup(server.Close)
+
+	widget := newTestRandomFactWidget(t, &randomFactWidget{Retries: -1}, server.URL)
+	_, _, err := widget.fetchRawFactOnce(context.Background(), widget.factURL)
+	if err == nil {
+		t.Fatal("Expected an error when the fact API returns HTML")
+	}
+
+	for _, expected := range []string{"text/html", "<!DOCTYPE html> <title>Just a moment...</title>"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error to contain %q, got %q", expected, err)
+		}
+	}
+}
+
+func TestRandomFactFallbackAPI(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+	}))
+	t.Cleanup(primary.Close)
+
+	fallback := newTestFactServer(t, []rawFactResponse{{ID: "1", Text: "Honey never spoils."}})
+
+	widget := newTestRandomFactWidget(t, &randomFactWidget{FallbackAPIURL: fallback.URL, Retries: -1}, primary.URL)
+	widget.update(context.Background())
+
+	if widget.CachedData == nil || widget.CachedData.FactText != "Honey never spoils." {
+		t.Fatalf("Expected fact from the fallback API, got %+v", widget.CachedData)
+	}
+
+	if expected := strings.TrimPrefix(fallback.URL, "http://"); widget.CachedData.Source != expected {
+		t.Errorf("Expected source to be the fallback API host %q, got %q", expected, widget.CachedData.Source)
+	}
+}
+
+func TestRandomFactAIConcurrency(t *testing.T) {
+	factServer := newTestFactServer(t, []rawFactResponse{
+		{ID: "1", Text: "Honey never spoils."},
+		{ID: "2", Text: "Bananas are berries."},
+		{ID: "3", Text: "Octopuses have three hearts."},
+	})
+
+	// 等待所有请求同时到达后再回复，以确认三条事实是并发处理的
+	var inFlight atomic.Int32
+	aiServer := newTestAIServerFunc(t, func(payload map[string]any) (string, string) {
+		inFlight.Add(1)
+		deadline := time.Now().Add(2 * time.Second)
+		for inFlight.Load() < 3 && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+
+		messages := fmt.Sprint(payload["messages"])
+		switch {
+		case strings.Contains(messages, "Honey"):
+			return "蜂蜜永远不会变质。", "stop"
+		case strings.Contains(messages, "Octopuses"):
+			return "章鱼有三颗心脏。", "stop"
+		}
+		return "", "stop"
+	})
+
+	widget := withTestAIServer(&randomFactWidget{Count: 3, AIConcurrency: 3}, aiServer)
+	newTestRandomFactWidget(t, widget, factServer.URL)
+	widget.update(context.Background())
+
+	if inFlight.Load() != 3 {
+		t.Fatalf("Expected 3 AI requests, got %d", inFlight.Load())
+	}
+
+	if len(widget.CachedFacts) != 3 {
+		t.Fatalf("Expected 3 facts, got %d", len(widget.CachedFacts))
+	}
+
+	expected := []string{"蜂蜜永远不会变质。", "Bananas are berries.", "章鱼有三颗心脏。"}
+	for i, fact := range widget.CachedFacts {
+		if fact.FactID != strconv.Itoa(i+1) {
+			t.Errorf("Expected fact %d to keep its position, got ID %s", i+1, fact.FactID)
+		}
+
+		content := cmp.Or(fact.Translation, fact.Content)
+		if content != expected[i] {
+			t.Errorf("Expected fact %d to show %q, got %q", i+1, expected[i], content)
+		}
+	}
+
+	if widget.CachedFacts[1].Translated {
+		t.Error("Expected the fact whose AI processing failed to fall back to the raw text")
+	}
+
+	if err := (&randomFactWidget{AIConcurrency: -1}).initialize(); err == nil {
+		t.Error("Expected a negative ai-concurrency to be rejected")
+	}
+}
+
+func TestRandomFactFetchRawFactResponses(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		contentType string
+		body        string
+		expectErr   bool
+	}{
+		{"success", http.StatusOK, "application/json", `{"id":"1","text":"Honey never spoils."}`, false},
+		{"non-200", http.StatusInternalServerError, "text/plain", "internal error", true},
+		{"malformed JSON", http.StatusOK, "text/html", "<html>blocked</html>", true},
+		{"empty data", http.StatusOK, "application/json", `{}`, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestStaticServer(t, test.status, test.contentType, test.body)
+			widget := newTestRandomFactWidget(t, &randomFactWidget{Retries: -1}, server.URL)
+
+			fact, err := widget.fetchRemoteFact(context.Background())
+			if test.expectErr {
+				if err == nil {
+					t.Fatalf("Expected an error, got fact %+v", fact)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if fact.ID != "1" || fact.Text != "Honey never spoils." {
+				t.Errorf("Expected the served fact, got %+v", fact)
+			}
+		})
+	}
+}
+
+func TestRandomFactProcessWithAIResponses(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		contentType string
+		body        string
+		expectErr   string
+	}{
+		{"success", http.StatusOK, "application/json", `{"choices":[{"message":{"content":"蜂蜜永远不会变质。"},"finish_reason":"stop"}]}`, ""},
+		{"non-200", http.StatusInternalServerError, "application/json", `{"error":{"message":"model overloaded"}}`, "model overloaded"},
+		{"malformed JSON", http.StatusOK, "text/html", "<html>gateway</html>", "<html>gateway</html>"},
+		{"empty data", http.StatusOK, "application/json", `{"choices":[]}`, errAIEmptyChoices.Error()},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestStaticServer(t, test.status, test.contentType, test.body)
+			widget := withTestAIServer(&randomFactWidget{AIRetries: -1}, server)
+			newTestRandomFactWidget(t, widget, server.URL)
+
+			content, _, err := widget.processWithAI(context.Background(), "Honey never spoils.", "")
+			if test.expectErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.expectErr) {
+					t.Fatalf("Expected an error containing %q, got %v", test.expectErr, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if content != "蜂蜜永远不会变质。" {
+				t.Errorf("Expected the served content, got %q", content)
+			}
+		})
+	}
+}
+
+func TestRandomFactMaxResponseBytes(t *testing.T) {
+	longFact := `{"id":"1","text":"` + strings.Repeat("a", 256) + `"}`
+
+	// 分块传输的响应没有 Content-Length，只能在读取时发现超出限制
+	chunked := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, longFact[:10])
+		w.(http.Flusher).Flush()
+		io.WriteString(w, longFact[10:])
+	}))
+	t.Cleanup(chunked.Close)
+
+	servers := map[string]*httptest.Server{
+		"content-length": newTestStaticServer(t, http.StatusOK, "application/json", longFact),
+		"chunked":        chunked,
+	}
+
+	for name, server := range servers {
+		widget := newTestRandomFactWidget(t, &randomFactWidget{Retries: -1, MaxResponseBytes: 64}, server.URL)
+
+		if _, err := widget.fetchRemoteFact(context.Background()); !errors.Is(err, errResponseTooLarge) {
+			t.Errorf("Expected a %s response over the limit to fail with errResponseTooLarge, got %v", name, err)
+		}
+
+		widget.MaxResponseBytes = int64(len(longFact))
+		if _, err := widget.fetchRemoteFact(context.Background()); err != nil {
+			t.Errorf("Expected a %s response within the limit to succeed, got %v", name, err)
+		}
+	}
+
+	if err := (&randomFactWidget{MaxResponseBytes: -1}).initialize(); err == nil {
+		t.Error("Expected a negative max-response-bytes to be rejected")
+	}
+}
+
+func TestRandomFactMaxStale(t *testing.T) {
+	var fail atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(rawFactResponse{ID: "1", Text: "Honey never spoils."})
+	}))
+	t.Cleanup(server.Close)
+
+	// 指定了事实文件的 remote 模式在失败时不会退回本地事实
+	factsFile := filepath.Join(t.TempDir(), "facts.txt")
+	if err := os.WriteFile(factsFile, []byte("Bananas are berries.\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write facts file: %v", err)
+	}
+
+	widget := newTestRandomFactWidget(t, &randomFactWidget{
+		widgetBase:       widgetBase{CustomCacheDuration: durationField(time.Minute)},
+		MaxStaleDuration: durationField(time.Hour),
+		Source:           factSourceRemote,
+		FactsFile:        factsFile,
+		Retries:          -1,
+	}, server.URL)
+	widget.update(context.Background())
+
+	// 缓存过期后更新失败，但仍在 max-stale 之内
+	fail.Store(true)
+	widget.lastUpdate = time.Now().Add(-10 * time.Minute)
+	widget.update(context.Background())
+
+	if widget.Error == nil {
+		t.Fatal("Expected the failed update to set an error")
+	}
+
+	if html := string(widget.Render()); !strings.Contains(html, "Honey never spoils.") || !widget.ContentAvailable {
+		t.Errorf("Expected the last good fact to stay visible within max-stale, got: %s", html)
+	}
+
+	widget.lastUpdate = time.Now().Add(-2 * time.Hour)
+	if html := string(widget.Render()); strings.Contains(html, "Honey never spoils.") || widget.ContentAvailable {
+		t.Errorf("Expected the error state once the fact is older than max-stale, got: %s", html)
+	}
+
+	// 恢复后重新展示内容
+	fail.Store(false)
+	widget.update(context.Background())
+
+	if html := string(widget.Render()); !strings.Contains(html, "Honey never spoils.") || !widget.ContentAvailable {
+		t.Errorf("Expected content to be shown again after a successful update, got: %s", html)
+	}
+}
+
+func TestRandomFactResponsePath(t *testing.T) {
+	tests := []struct {
+		name      string
+		path      string
+		body      string
+		expected  string
+		expectErr string
+	}{
+		{"default", "", `{"choices":[{"message":{"content":"蜂蜜永远不会变质。","reasoning_content":"思考过程"}}]}`, "蜂蜜永远不会变质。", ""},
+		{"reasoning field", "choices.0.message.reasoning_content", `{"choices":[{"message":{"content":"","reasoning_content":"蜂蜜永远不会变质。"}}]}`, "蜂蜜永远不会变质。", ""},
+		{"nested gateway", "result.output.text", `{"result":{"output":{"text":"蜂蜜永远不会变质。"}}}`, "蜂蜜永远不会变质。", ""},
+		{"missing path", "result.output.text", `{"result":{}}`, "", "no value at response-path"},
+		{"not a string", "result.output", `{"result":{"output":{"text":"x"}}}`, "", "is not a string"},
+		{"empty choices", "choices.0.message.reasoning_content", `{"choices":[]}`, "", errAIEmptyChoices.Error()},
+		{"api error", "result.output.text", `{"error":{"message":"quota exceeded"}}`, "", "quota exceeded"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestStaticServer(t, http.StatusOK, "application/json", test.body)
+			widget := withTestAIServer(&randomFactWidget{AIRetries: -1, ResponsePath: test.path}, server)
+			newTestRandomFactWidget(t, widget, server.URL)
+
+			content, _, err := widget.processWithAI(context.Background(), "Honey never spoils.", "")
+			if test.expectErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.expectErr) {
+					t.Fatalf("Expected an error containing %q, got %v", test.expectErr, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if content != test.expected {
+				t.Errorf("Expected %q, got %q", test.expected, content)
+			}
+		})
+	}
+
+	if err := (&randomFactWidget{ResponsePath: "result.text", Stream: true}).initialize(); err == nil {
+		t.Error("Expected response-path to be rejected together with stream")
+	}
+}
+
+func TestRandomFactRefreshThroughRouter(t *testing.T) {
+	factServer := newTestFactServer(t, []rawFactResponse{
+		{ID: "1", Text: "Honey never spoils."},
+		{ID: "2", Text: "Bananas are berries."},
+	})
+
+	config, err := newConfigFromYAML([]byte(`
+pages:
+  - name: Home
+    columns:
+      - size: full
+        widgets:
+          - type: random-fact
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	app, err := newApplication(config)
+	if err != nil {
+		t.Fatalf("Failed to create application: %v", err)
+	}
+
+	var widget *randomFactWidget
+	for _, w := range app.widgetByID {
+		widget = w.(*randomFactWidget)
+	}
+	widget.factURL = factServer.URL
+
+	server := httptest.NewServer(app.newServeMux())
+	t.Cleanup(server.Close)
+
+	// 页面渲染时进行首次更新
+	response, err := http.Get(server.URL + "/api/pages/home/content/")
+	if err != nil {
+		t.Fatalf("Failed to request page content: %v", err)
+	}
+	response.Body.Close()
+
+	if widget.CachedData == nil {
+		t.Fatal("Expected the page render to fetch a fact")
+	}
+	first := widget.CachedData.FactText
+
+	url := fmt.Sprintf("%s/api/widgets/%d/?format=json", server.URL, widget.GetID())
+	response, err = http.Post(url, "", nil)
+	if err != nil {
+		t.Fatalf("Failed to request refresh: %v", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200 from the widget endpoint, got %d", response.StatusCode)
+	}
+
+	var status struct {
+		OK   bool            `json:"ok"`
+		Fact *randomFactData `json:"fact"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&status); err != nil {
+		t.Fatalf("Failed to decode widget response: %v", err)
+	}
+
+	// 事实接口依次返回两条事实，刷新后应得到第二条
+	if !status.OK || status.Fact == nil || status.Fact.FactText == first {
+		t.Errorf("Expected the refresh to fetch a different fact than %q, got %+v", first, status.Fact)
+	}
+
+	response, err = http.Get(server.URL + "/api/widgets/12345/")
+	if err != nil {
+		t.Fatalf("Failed to request unknown widget: %v", err)
+	}
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown widget, got %d", response.StatusCode)
+	}
+}
+
+func TestRandomFactRefreshDoesNotBlockPageRender(t *testing.T) {
+	// 第一次请求立即返回，之后的请求一直等待到测试结束
+	release := make(chan struct{})
+	requested := make(chan struct{}, 1)
+	var served atomic.Int32
+	factServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if served.Add(1) > 1 {
+			select {
+			case requested <- struct{}{}:
+			default:
+			}
+			<-release
+		}
+		json.NewEncoder(w).Encode(rawFactResponse{ID: "1", Text: "Honey never spoils."})
+	}))
+	t.Cleanup(factServer.Close)
+
+	config, err := newConfigFromYAML([]byte(`
+pages:
+  - name: Home
+    columns:
+      - size: full
+        widgets:
+          - type: random-fact
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	app, err := newApplication(config)
+	if err != nil {
+		t.Fatalf("Failed to create application: %v", err)
+	}
+
+	var widget *randomFactWidget
+	for _, w := range app.widgetByID {
+		widget = w.(*randomFactWidget)
+	}
+	widget.factURL = factServer.URL
+
+	server := httptest.NewServer(app.newServeMux())
+	t.Cleanup(server.Close)
+	// 在关闭服务器之前放行等待中的刷新
+	t.Cleanup(func() { close(release) })
+
+	client := &http.Client{Timeout: 2 * time.Second}
+	getPage := func() string {
+		response, err := client.Get(server.URL + "/api/pages/home/content/")
+		if err != nil {
+			t.Fatalf("Failed to request page content: %v", err)
+		}
+		defer response.Body.Close()
+
+		body, _ := io.ReadAll(response.Body)
+		return string(body)
+	}
+
+	if page := getPage(); !strings.Contains(page, "Honey never spoils.") {
+		t.Fatalf("Expected the first page render to show the fact, got: %s", page)
+	}
+
+	go http.Post(fmt.Sprintf("%s/api/widgets/%d/", server.URL, widget.GetID()), "", nil)
+	<-requested
+
+	// 刷新仍在等待上游时，页面渲染不应被阻塞，继续展示上一次的内容
+	if page := getPage(); !strings.Contains(page, "Honey never spoils.") {
+		t.Errorf("Expected the page to render the previous fact during a refresh, got: %s", page)
+	}
+}
+
+func TestRandomFactFailedRefreshKeepsLastUpdate(t *testing.T) {
+	var fail atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(rawFactResponse{ID: "1", Text: "Honey never spoils."})
+	}))
+	t.Cleanup(server.Close)
+
+	factsFile := filepath.Join(t.TempDir(), "facts.txt")
+	if err := os.WriteFile(factsFile, []byte("Bananas are berries.\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write facts file: %v", err)
+	}
+
+	widget := newTestRandomFactWidget(t, &randomFactWidget{
+		MaxStaleDuration: durationField(time.Hour),
+		Source:           factSourceRemote,
+		FactsFile:        factsFile,
+		Retries:          -1,
+	}, server.URL)
+	widget.update(context.Background())
+
+	lastUpdate := time.Now().Add(-2 * time.Hour)
+	widget.lastUpdate = lastUpdate
+
+	fail.Store(true)
+	widget.refresh(context.Background())
+
+	if !widget.lastUpdate.Equal(lastUpdate) {
+		t.Errorf("Expected a failed refresh to keep the last update time, got %v", widget.lastUpdate)
+	}
+
+	if widget.Error == nil || !widget.isPastMaxStale() {
+		t.Error("Expected the fact to be reported as past max-stale after a failed refresh")
+	}
+}
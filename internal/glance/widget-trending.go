@@ -0,0 +1,154 @@
+package glance
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"sync"
+)
+
+var trendingWidgetTemplate = mustParseTemplate("trending.html", "widget-base.html")
+
+// 支持聚合的热榜来源及其展示名称和链接
+var trendingSourceInfo = map[string]struct {
+	name string
+	url  string
+}{
+	"weibo": {"微博热搜", "https://s.weibo.com/top/summary"},
+	"zhihu": {"知乎热榜", "https://www.zhihu.com/hot"},
+}
+
+// 聚合多个平台热榜的Widget，每个平台单独展示为一组
+type trendingWidget struct {
+	hotSearchWidgetBase `yaml:",inline"`
+
+	// 要聚合的平台，按顺序展示
+	Sources []string `yaml:"sources"`
+
+	// 内部数据
+	Sections      []trendingSection `yaml:"-"`
+	FailedSources int               `yaml:"-"`
+	sources       []hotSearchSource
+}
+
+// 单个平台的热榜分组，获取失败时 Error 不为空
+type trendingSection struct {
+	Source string
+	Name   string
+	URL    string
+	Items  []hotSearchItem
+	Error  error
+}
+
+func (widget *trendingWidget) initialize() error {
+	if err := widget.initializeHotSearch("Trending", widget.logger()); err != nil {
+		return err
+	}
+
+	if len(widget.Sources) == 0 {
+		widget.Sources = []string{"weibo", "zhihu"}
+	}
+
+	widget.sources = make([]hotSearchSource, 0, len(widget.Sources))
+	for _, name := range widget.Sources {
+		source, err := newTrendingSource(name, widget.Timeout)
+		if err != nil {
+			return err
+		}
+		widget.sources = append(widget.sources, source)
+	}
+
+	return nil
+}
+
+// 创建热榜来源，各来源获取尽可能多的条目，筛选和截断由 trending 组件统一进行
+// 请求超时使用 trending 组件的 timeout
+func newTrendingSource(name string, timeout durationField) (hotSearchSource, error) {
+	var source interface {
+		hotSearchSource
+		initialize() error
+	}
+
+	switch name {
+	case "weibo":
+		source = &weiboWidget{hotSearchWidgetBase: hotSearchWidgetBase{ShowCount: 50, Timeout: timeout}}
+	case "zhihu":
+		source = &zhihuWidget{hotSearchWidgetBase: hotSearchWidgetBase{ShowCount: 50, Timeout: timeout}}
+	default:
+		return nil, fmt.Errorf("unknown trending source '%s'", name)
+	}
+
+	if err := source.initialize(); err != nil {
+		return nil, fmt.Errorf("initializing %s source: %v", name, err)
+	}
+
+	return source, nil
+}
+
+func (widget *trendingWidget) update(ctx context.Context) {
+	// 所有来源持续失败时在退避期间内不再请求
+	if widget.breaker.isOpen() {
+		return
+	}
+
+	sections := make([]trendingSection, len(widget.sources))
+
+	var wg sync.WaitGroup
+	for i, source := range widget.sources {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			name := widget.Sources[i]
+			section := trendingSection{
+				Source: name,
+				Name:   trendingSourceInfo[name].name,
+				URL:    trendingSourceInfo[name].url,
+			}
+
+			items, err := source.fetchHotSearchItems(ctx)
+			if err != nil {
+				widget.logger().Error("Failed to fetch trending source", "source", name, "error", err)
+				section.Error = err
+			} else {
+				section.Items = truncateHotSearches(&widget.hotSearchWidgetBase, applyHotSearchFilters(&widget.hotSearchWidgetBase, items))
+			}
+
+			sections[i] = section
+		}()
+	}
+	wg.Wait()
+
+	// 只有所有来源都失败时才视为错误，否则展示获取成功的部分并给出提示
+	var errs sourceErrors
+	for i := range sections {
+		if sections[i].Error != nil {
+			errs = append(errs, sections[i].Error)
+		}
+	}
+
+	if !widget.handleUpdateResult(errs.err(len(sections)), widget.logger(), "Failed to fetch all trending sources") {
+		return
+	}
+
+	widget.Sections = sections
+	widget.FailedSources = len(errs)
+}
+
+func (widget *trendingWidget) setProviders(providers *widgetProviders) {
+	widget.Providers = providers
+
+	for _, source := range widget.sources {
+		source.setProviders(providers)
+	}
+}
+
+// 带有Widget类型和ID的日志记录器
+func (widget *trendingWidget) logger() *slog.Logger {
+	return slog.With("widget", "trending", "widget_id", widget.ID)
+}
+
+func (widget *trendingWidget) Render() template.HTML {
+	return widget.renderTemplate(widget, trendingWidgetTemplate)
+}
@@ -1,19 +1,26 @@
 package glance
 
 import (
+	"bytes"
+	"cmp"
 	"context"
 	"crypto/tls"
 	"encoding/json"
 	"encoding/xml"
 	"errors"
 	"fmt"
+	"html/template"
 	"io"
+	"log/slog"
 	"math/rand/v2"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+	"unicode"
 )
 
 var (
@@ -23,12 +30,27 @@ var (
 
 const defaultClientTimeout = 5 * time.Second
 
+// defaultHTTPTransport holds the connection pool shared by defaultHTTPClient
+// and sharedHTTPClient, which only differ in their timeouts
+var defaultHTTPTransport = &http.Transport{
+	MaxIdleConnsPerHost: 10,
+	Proxy:               http.ProxyFromEnvironment,
+}
+
 var defaultHTTPClient = &http.Client{
-	Transport: &http.Transport{
-		MaxIdleConnsPerHost: 10,
-		Proxy:               http.ProxyFromEnvironment,
-	},
-	Timeout: defaultClientTimeout,
+	Transport: defaultHTTPTransport,
+	Timeout:   defaultClientTimeout,
+}
+
+// sharedHTTPClientTimeout is only an upper bound, widgets that need a shorter
+// timeout set a deadline on the request context instead
+const sharedHTTPClientTimeout = 2 * time.Minute
+
+// sharedHTTPClient is handed to widgets through widgetProviders so that
+// requests made by different widgets reuse the same pooled connections
+var sharedHTTPClient = &http.Client{
+	Transport: defaultHTTPTransport,
+	Timeout:   sharedHTTPClientTimeout,
 }
 
 var defaultInsecureHTTPClient = &http.Client{
@@ -59,6 +81,429 @@ func setBrowserUserAgentHeader(request *http.Request) {
 	request.Header.Set("User-Agent", getBrowserUserAgentHeader())
 }
 
+const retryBackoffBaseDelay = 500 * time.Millisecond
+
+// retryBackoffDelay returns the exponential backoff delay for the given zero-based retry attempt
+func retryBackoffDelay(attempt int) time.Duration {
+	return retryBackoffBaseDelay << attempt
+}
+
+func isRetryableStatusCode(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+func sleepWithContext(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// sourceErrors collects the errors of an update that fetches from multiple
+// independent sources, so that the sources which succeeded can still be shown
+type sourceErrors []error
+
+// err returns nil when every source succeeded, an error wrapping
+// errPartialContent when only some failed and errNoContent when all did
+func (errs sourceErrors) err(total int) error {
+	if len(errs) == 0 {
+		return nil
+	}
+
+	if len(errs) >= total {
+		return fmt.Errorf("%w: %w", errNoContent, errors.Join(errs...))
+	}
+
+	return fmt.Errorf("%w: %d of %d sources failed: %w", errPartialContent, len(errs), total, errors.Join(errs...))
+}
+
+const (
+	// number of consecutive failed updates after which a circuitBreaker opens
+	circuitBreakerThreshold = 3
+	circuitBreakerBaseDelay = 5 * time.Minute
+	circuitBreakerMaxDelay  = 30 * time.Minute
+)
+
+// circuitBreaker backs off from an upstream that keeps failing. Once an update
+// has failed circuitBreakerThreshold times in a row, the next attempt is
+// delayed exponentially up to circuitBreakerMaxDelay, and the first success
+// resets it
+type circuitBreaker struct {
+	failures  int
+	openUntil time.Time
+}
+
+// isOpen reports whether updates should be skipped because the upstream is
+// still being backed off from
+func (b *circuitBreaker) isOpen() bool {
+	return time.Now().Before(b.openUntil)
+}
+
+// recordFailure returns how long to back off before the next attempt, which
+// is zero while the number of consecutive failures is below the threshold
+func (b *circuitBreaker) recordFailure() time.Duration {
+	b.failures++
+	if b.failures < circuitBreakerThreshold {
+		return 0
+	}
+
+	delay := min(circuitBreakerBaseDelay<<min(b.failures-circuitBreakerThreshold, 8), circuitBreakerMaxDelay)
+	b.openUntil = time.Now().Add(delay)
+
+	return delay
+}
+
+func (b *circuitBreaker) recordSuccess() {
+	b.failures = 0
+	b.openUntil = time.Time{}
+}
+
+// handleFailure sets err on the widget and schedules an early update, delaying
+// it further when the breaker opens. The error is logged once at warning level
+// when the breaker opens and only at debug level while it stays open, so that
+// an upstream which is down for a while doesn't flood the logs
+func (b *circuitBreaker) handleFailure(w *widgetBase, logger *slog.Logger, msg string, err error) {
+	w.withError(err).scheduleEarlyUpdate()
+
+	delay := b.recordFailure()
+	switch {
+	case delay == 0:
+		logger.Error(msg, "error", err)
+	case b.failures == circuitBreakerThreshold:
+		logger.Warn(msg+", backing off", "error", err, "failures", b.failures, "retry_in", delay)
+	default:
+		logger.Debug(msg, "error", err, "failures", b.failures, "retry_in", delay)
+	}
+
+	if delay > 0 && b.openUntil.After(w.nextUpdate) {
+		w.nextUpdate = b.openUntil
+	}
+}
+
+// widgetStats counts the requests a widget makes to its upstream APIs so that
+// operators can see how often endpoints are hit and how often they fail
+type widgetStats struct {
+	fetches     atomic.Int64
+	cacheHits   atomic.Int64
+	apiErrors   atomic.Int64
+	aiFallbacks atomic.Int64
+}
+
+// widgetStatsSnapshot is a point in time copy of widgetStats
+type widgetStatsSnapshot struct {
+	// requests sent to upstream APIs, including retries
+	Fetches int64 `json:"fetches"`
+	// updates or AI results served from a cache without a request
+	CacheHits int64 `json:"cache_hits"`
+	// upstream requests which failed
+	APIErrors int64 `json:"api_errors"`
+	// facts shown as raw text because AI processing failed
+	AIFallbacks int64 `json:"ai_fallbacks"`
+}
+
+// recordFetch counts an upstream request along with its outcome
+func (s *widgetStats) recordFetch(err error) {
+	s.fetches.Add(1)
+	if err != nil {
+		s.apiErrors.Add(1)
+	}
+}
+
+func (s *widgetStats) snapshot() widgetStatsSnapshot {
+	return widgetStatsSnapshot{
+		Fetches:     s.fetches.Load(),
+		CacheHits:   s.cacheHits.Load(),
+		APIErrors:   s.apiErrors.Load(),
+		AIFallbacks: s.aiFallbacks.Load(),
+	}
+}
+
+// maximum number of bytes of the response body included in an httpStatusError
+const httpStatusErrorBodyLength = 512
+
+// httpStatusError is returned by fetchResponse for responses other than 200 OK
+// and includes the beginning of the response body to help with debugging
+type httpStatusError struct {
+	StatusCode int
+	URL        string
+	Body       string
+}
+
+func (e *httpStatusError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("unexpected status code %d from %s", e.StatusCode, e.URL)
+	}
+
+	return fmt.Sprintf("unexpected status code %d from %s, response: %s", e.StatusCode, e.URL, e.Body)
+}
+
+// fetchResponse sends a request with the given headers and body, returning
+// the response if its status is 200 OK and an *httpStatusError otherwise.
+// The caller is responsible for closing the body of the returned response.
+func fetchResponse(
+	ctx context.Context,
+	client requestDoer,
+	method string,
+	url string,
+	headers map[string]string,
+	body io.Reader,
+) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		defer response.Body.Close()
+
+		contents, _ := io.ReadAll(io.LimitReader(response.Body, httpStatusErrorBodyLength+1))
+
+		return nil, &httpStatusError{
+			StatusCode: response.StatusCode,
+			URL:        request.URL.String(),
+			Body:       errorBodySnippet(contents),
+		}
+	}
+
+	return response, nil
+}
+
+// errorBodySnippet turns the beginning of a response body into a single line
+// suitable for an error message, replacing control characters with spaces and
+// marking the snippet as truncated if the body is longer than the limit
+func errorBodySnippet(body []byte) string {
+	truncated := len(body) > httpStatusErrorBodyLength
+	if truncated {
+		body = body[:httpStatusErrorBodyLength]
+	}
+
+	// drop a multi-byte character that was cut in half by the limit
+	snippet := strings.ToValidUTF8(string(body), "")
+	snippet = strings.Join(strings.FieldsFunc(snippet, unicode.IsControl), " ")
+	snippet = strings.TrimSpace(snippet)
+
+	if truncated && snippet != "" {
+		snippet += "…"
+	}
+
+	return snippet
+}
+
+// defaultMaxResponseBytes is how much of a response body is read unless a
+// widget configures a different limit
+const defaultMaxResponseBytes = 4 << 20
+
+var errResponseTooLarge = errors.New("response body is too large")
+
+// limitedResponseBody returns a reader for the body of response that fails
+// with an error wrapping errResponseTooLarge once more than limit bytes have
+// been read. Responses declaring a larger Content-Length fail right away
+// without reading anything, so that a misbehaving endpoint can't exhaust memory
+func limitedResponseBody(response *http.Response, limit int64) (io.Reader, error) {
+	if response.ContentLength > limit {
+		return nil, fmt.Errorf("%w, Content-Length of %d bytes exceeds the limit of %d bytes", errResponseTooLarge, response.ContentLength, limit)
+	}
+
+	return &limitedReader{reader: io.LimitReader(response.Body, limit+1), limit: limit}, nil
+}
+
+type limitedReader struct {
+	reader io.Reader
+	limit  int64
+	read   int64
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.read += int64(n)
+
+	if r.read > r.limit {
+		return n - int(r.read-r.limit), fmt.Errorf("%w, exceeded the limit of %d bytes", errResponseTooLarge, r.limit)
+	}
+
+	return n, err
+}
+
+// readResponseBody reads the whole body of response, up to limit bytes
+func readResponseBody(response *http.Response, limit int64) ([]byte, error) {
+	body, err := limitedResponseBody(response, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(body)
+}
+
+// fetchJSON sends a request using fetchResponse and decodes the JSON response
+// into out, reading at most defaultMaxResponseBytes of the response
+func fetchJSON(
+	ctx context.Context,
+	client requestDoer,
+	method string,
+	url string,
+	headers map[string]string,
+	body io.Reader,
+	out any,
+) error {
+	return fetchJSONWithLimit(ctx, client, method, url, headers, body, defaultMaxResponseBytes, out)
+}
+
+// fetchJSONWithLimit is fetchJSON with a custom limit on the size of the response
+func fetchJSONWithLimit(
+	ctx context.Context,
+	client requestDoer,
+	method string,
+	url string,
+	headers map[string]string,
+	body io.Reader,
+	limit int64,
+	out any,
+) error {
+	response, err := fetchResponse(ctx, client, method, url, headers, body)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	// read the whole body first so that it can be included in the error if it
+	// turns out not to be JSON, such as an HTML challenge page from a CDN
+	contents, err := readResponseBody(response, limit)
+	if err != nil {
+		return fmt.Errorf("reading response from %s: %w", url, err)
+	}
+
+	if err := json.NewDecoder(bytes.NewReader(contents)).Decode(out); err != nil {
+		return fmt.Errorf("decoding response from %s: %w", url, newJSONDecodeError(response.Header.Get("Content-Type"), contents, err))
+	}
+
+	return nil
+}
+
+// jsonDecodeError wraps a JSON decoding error with the content type and the
+// beginning of the body that failed to decode, so that errors such as
+// "invalid character '<'" show what was actually returned
+type jsonDecodeError struct {
+	ContentType string
+	Body        string
+	Err         error
+}
+
+func newJSONDecodeError(contentType string, body []byte, err error) *jsonDecodeError {
+	return &jsonDecodeError{
+		ContentType: contentType,
+		Body:        errorBodySnippet(body),
+		Err:         err,
+	}
+}
+
+func (e *jsonDecodeError) Error() string {
+	contentType := cmp.Or(e.ContentType, "unknown")
+
+	if e.Body == "" {
+		return fmt.Sprintf("%v (content type %s, empty response)", e.Err, contentType)
+	}
+
+	return fmt.Sprintf("%v (content type %s, response: %s)", e.Err, contentType, e.Body)
+}
+
+func (e *jsonDecodeError) Unwrap() error {
+	return e.Err
+}
+
+// isRetryableFetchError reports whether an error returned by fetchResponse or
+// fetchJSON is worth retrying, which is the case for network errors while the
+// parent context is still active as well as 429 and 5xx responses
+func isRetryableFetchError(ctx context.Context, err error) bool {
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		return isRetryableStatusCode(statusErr.StatusCode)
+	}
+
+	var urlErr *url.Error
+	return errors.As(err, &urlErr) && ctx.Err() == nil
+}
+
+// safeURL marks an externally sourced URL as safe to render in a link, returning
+// an empty URL unless it is an absolute http or https URL so that values such as
+// javascript: links coming from an upstream API never reach the page
+func safeURL(raw string) template.URL {
+	raw = strings.TrimSpace(raw)
+
+	parsed, err := url.Parse(raw)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return ""
+	}
+
+	return template.URL(raw)
+}
+
+const (
+	relativeLanguageChinese = "zh"
+	relativeLanguageEnglish = "en"
+)
+
+func validateRelativeLanguage(language string) error {
+	switch language {
+	case "", relativeLanguageChinese, relativeLanguageEnglish:
+		return nil
+	}
+
+	return fmt.Errorf("relative-language must be one of: %s, %s", relativeLanguageChinese, relativeLanguageEnglish)
+}
+
+// formatRelativeTime describes how long ago t was, such as "5分钟前" or
+// "5 minutes ago" depending on the language, which defaults to Chinese.
+// An empty string is returned for the zero time
+func formatRelativeTime(t time.Time, now time.Time, language string) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	elapsed := max(now.Sub(t), 0)
+	english := language == relativeLanguageEnglish
+
+	var value int
+	var zhUnit, enUnit string
+
+	switch {
+	case elapsed < time.Minute:
+		if english {
+			return "just now"
+		}
+		return "刚刚"
+	case elapsed < time.Hour:
+		value, zhUnit, enUnit = int(elapsed/time.Minute), "分钟", "minute"
+	case elapsed < 24*time.Hour:
+		value, zhUnit, enUnit = int(elapsed/time.Hour), "小时", "hour"
+	default:
+		value, zhUnit, enUnit = int(elapsed/(24*time.Hour)), "天", "day"
+	}
+
+	if !english {
+		return strconv.Itoa(value) + zhUnit + "前"
+	}
+
+	if value != 1 {
+		enUnit += "s"
+	}
+
+	return strconv.Itoa(value) + " " + enUnit + " ago"
+}
+
 func decodeJsonFromRequest[T any](client requestDoer, request *http.Request) (T, error) {
 	var result T
 
@@ -164,13 +609,13 @@ func (job *workerPoolJob[I, O]) withWorkers(workers int) *workerPoolJob[I, O] {
 	return job
 }
 
-// func (job *workerPoolJob[I, O]) withContext(ctx context.Context) *workerPoolJob[I, O] {
-// 	if ctx != nil {
-// 		job.ctx = ctx
-// 	}
+func (job *workerPoolJob[I, O]) withContext(ctx context.Context) *workerPoolJob[I, O] {
+	if ctx != nil {
+		job.ctx = ctx
+	}
 
-// 	return job
-// }
+	return job
+}
 
 func newJob[I any, O any](task func(I) (O, error), data []I) *workerPoolJob[I, O] {
 	return &workerPoolJob[I, O]{
@@ -1,88 +1,299 @@
 package glance
 
 import (
+	"bytes"
+	"cmp"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
+	"log/slog"
+	"maps"
+	"math"
+	"mime"
 	"net/http"
 	"net/url"
+	"os"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
+
+	"golang.org/x/text/encoding/htmlindex"
 )
 
 var weiboWidgetTemplate = mustParseTemplate("weibo.html", "widget-base.html")
 
+// 写入榜单快照的最长等待时间
+const weiboSnapshotWriteTimeout = 2 * time.Second
+
+// 分块写入快照文件时每块的大小，每块写入之前检查是否已经超时
+const weiboSnapshotChunkSize = 4 << 10
+
+// 默认的请求失败重试次数
+const defaultWeiboRetries = 2
+
+// 默认的热搜接口地址
+const weiboAPIURL = "https://weibo.com/ajax/side/hotSearch"
+
+// 默认使用的浏览器User-Agent，过旧的版本更容易被反爬虫规则拦截
+const weiboDefaultUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/141.0.0.0 Safari/537.36"
+
+// 热度值的显示格式
+const (
+	weiboHotValueFormatLatin   = "latin"
+	weiboHotValueFormatChinese = "chinese"
+)
+
+// 热搜的排序方式
+const (
+	weiboSortByNone = "none"
+	weiboSortByRank = "rank"
+	weiboSortByNum  = "num"
+
+	// 按热度合并实时热搜和政府热搜，政府热搜的热度乘以 gov-weight
+	weiboSortByTrending = "trending"
+)
+
+// 热搜链接的样式
+const (
+	weiboLinkStyleDesktop = "desktop"
+	weiboLinkStyleMobile  = "mobile"
+)
+
+// 与上一次更新相比排名的变化，首次更新时为空
+const (
+	weiboRankDeltaUp   = "up"
+	weiboRankDeltaDown = "down"
+	weiboRankDeltaNew  = "new"
+	weiboRankDeltaSame = "same"
+)
+
+// 保留的总热度历史快照数量
+const weiboActivityHistoryLength = 12
+
 type weiboWidget struct {
-	widgetBase `yaml:",inline"`
-	
+	hotSearchWidgetBase `yaml:",inline"`
+
 	// 配置参数
-	ShowCount     int    `yaml:"show-count"`
-	Limit         int    `yaml:"limit"`
-	Category      string `yaml:"category"`
-	RefreshInterval int   `yaml:"refresh-interval"`
-	
+	Categories       []string      `yaml:"categories"`
+	Ticker           bool          `yaml:"ticker"`
+	ShowActivity     bool          `yaml:"show-activity"`
+	ShowDistribution bool          `yaml:"show-distribution"`
+	StaleAfter       durationField `yaml:"stale-after"`
+	FeatureTop       bool          `yaml:"feature-top"`
+	StrictJSON       bool          `yaml:"strict-json"`
+	CleanQuery       bool          `yaml:"clean-query"`
+
+	// 整理展示的关键词：去除包裹话题的#号并合并多余的空白，不影响搜索链接
+	CleanWords bool `yaml:"clean-words"`
+
+	// 开启 clean-words 时同时去除关键词中的表情符号
+	StripEmoji bool `yaml:"strip-emoji"`
+
+	// 关注的话题，关键词包含其中任意一项（不区分大小写）时高亮显示
+	Highlight []string `yaml:"highlight"`
+
+	// 网络错误和5xx/429时的重试次数，默认2次，负数表示不重试
+	Retries int `yaml:"retries"`
+
+	// 读取响应的最大字节数，防止异常的接口返回过大的内容
+	MaxResponseBytes int64 `yaml:"max-response-bytes"`
+
+	// 响应内容的字符集，为空时根据 Content-Type 判断，默认UTF-8
+	Charset string `yaml:"charset"`
+
+	// 排名补零对齐，如 01、02 ... 10
+	PadRank bool `yaml:"pad-rank"`
+
+	// 过滤推广的热搜，默认开启
+	ExcludeAdsRaw *bool `yaml:"exclude-ads"`
+	ExcludeAds    bool  `yaml:"-"`
+
+	// 热度低于该值的热搜不展示，0表示不过滤
+	MinHotValue int64 `yaml:"min-hot-value"`
+
+	// 始终展示在榜单顶部的固定条目，未设置链接时使用微博搜索链接
+	StaticItems []weiboStaticItem `yaml:"static-items"`
+
+	// 热度值的格式：latin（K/M）或 chinese（万/亿）
+	HotValueFormat string `yaml:"hot-value-format"`
+
+	// 热度值保留的小数位数（0-2），默认1位
+	HotPrecisionRaw *int `yaml:"hot-precision"`
+	HotPrecision    int  `yaml:"-"`
+
+	// 过滤后的排序方式：rank、num 或 none（保持接口顺序）
+	SortBy string `yaml:"sort-by"`
+
+	// sort-by 为 trending 时政府热搜的热度权重，0 表示排在最后，1 表示与实时热搜同等对待
+	GovWeightRaw *float64 `yaml:"gov-weight"`
+	GovWeight    float64  `yaml:"-"`
+
+	// 显示榜单的类别多样性
+	ShowDiversity bool `yaml:"show-diversity"`
+
+	// 在每条热搜旁显示与上一次更新相比的排名变化
+	ShowRankChange bool `yaml:"show-rank-change"`
+
+	// 热搜接口地址，可指向镜像或缓存代理
+	APIURL string `yaml:"apiurl"`
+
+	// 每次成功更新后以JSON Lines格式追加榜单快照的文件
+	SnapshotFile string `yaml:"snapshot-file"`
+
+	// 获取成功但筛选后没有剩余热搜时显示的提示
+	EmptyMessage string `yaml:"empty-message"`
+
+	// 显示热搜条目的图标（新、热、沸等）
+	ShowIcons bool `yaml:"show-icons"`
+
+	// 已登录会话的Cookie，未登录的请求更容易被限流或只返回部分数据
+	Cookie string `yaml:"cookie"`
+
+	// 请求使用的User-Agent，为空时使用内置的浏览器User-Agent
+	UserAgent string `yaml:"user-agent"`
+
+	// 额外的请求头，会覆盖默认值
+	Headers map[string]string `yaml:"headers"`
+
+	// 是否在新标签页中打开热搜链接，默认开启
+	NewTabRaw *bool `yaml:"new-tab"`
+	NewTab    bool  `yaml:"-"`
+
+	// 是否包含政府热搜，默认开启
+	IncludeGovRaw *bool `yaml:"include-gov"`
+	IncludeGov    bool  `yaml:"-"`
+
+	// 将政府热搜作为单独的分组展示，而不是合并到实时热搜中
+	GovSection bool `yaml:"gov-section"`
+
+	// 展示更新时间使用的时区，如 Asia/Shanghai，默认使用服务器本地时区
+	Timezone string `yaml:"timezone"`
+
+	// 相对更新时间（如"5分钟前"）使用的语言：zh 或 en，默认 zh
+	RelativeLanguage string `yaml:"relative-language"`
+
+	// 热搜链接的样式：desktop（s.weibo.com）或 mobile（m.weibo.cn）
+	LinkStyle string `yaml:"link-style"`
+
+	// 需要隐藏的热搜类别，可以直接配置或从文件中加载（每行一个）
+	ExcludeCategories     []string `yaml:"exclude-categories"`
+	ExcludeCategoriesFile string   `yaml:"exclude-categories-file"`
+
 	// 内部数据
-	HotSearches   []struct {
-		weiboHotSearchItem
-		URL string
-	} `yaml:"-"`
-	LastUpdated   time.Time            `yaml:"-"`
+	HotSearches     []weiboHotSearchEntry `yaml:"-"`
+	GovHotSearches  []weiboHotSearchEntry `yaml:"-"`
+	LastUpdated     time.Time             `yaml:"-"`
+	ActivityHistory []int64               `yaml:"-"`
+	CategoryCounts  map[string]int        `yaml:"-"`
+	Diversity       float64               `yaml:"-"`
+	Headline        *weiboHotSearchEntry  `yaml:"-"`
+
+	previousRanks      map[string]int
+	highlightTerms     []string
+	snapshotSink       weiboSnapshotSink
+	buildSearchURL     func(item *weiboHotSearchItem) string
+	location           *time.Location
+	excludedCategories map[string]struct{}
+
+	// 保护展示的数据，组件请求不持有页面锁，可能与更新和渲染同时进行
+	mu sync.Mutex
+}
+
+// 配置的固定条目
+type weiboStaticItem struct {
+	Word string `yaml:"word"`
+	URL  string `yaml:"url"`
+}
+
+// 模板中使用的热搜条目
+type weiboHotSearchEntry struct {
+	weiboHotSearchItem
+	URL template.URL
+
+	// 在榜单中的展示位置（从1开始）以及补零后的排名宽度
+	Position  int
+	rankWidth int
+
+	// 是否为配置的固定条目
+	Static bool
+
+	// 为空时使用默认格式
+	hotValue *weiboHotValueStyle
+
+	// 是否展示热搜图标
+	showIcons bool
+
+	// 开启 clean-words 时整理后的关键词
+	displayWord string
+
+	// 关键词是否包含 highlight 中的话题
+	Highlighted bool
+
+	// 与上一次更新相比的排名变化以及上一次的排名
+	rankDelta    string
+	previousRank int
 }
 
 // 微博热搜项结构
 type weiboHotSearchItem struct {
-	Icon             string `json:"icon"`               // 图标URL
-	IconWidth        int    `json:"icon_width"`         // 图标宽度
-	IconHeight       int    `json:"icon_height"`        // 图标高度
-	Emoticon         string `json:"emoticon"`           // 表情
-	Num              int64  `json:"num"`                // 热度值
-	Note             string `json:"note"`               // 备注
-	RealPos          int    `json:"realpos"`            // 实际排名
-	LabelName        string `json:"label_name"`         // 标签名称
-	SmallIconDesc    string `json:"small_icon_desc"`    // 小图标描述
-	SmallIconDescColor string `json:"small_icon_desc_color"` // 小图标描述颜色
-	Flag             int    `json:"flag"`               // 标志
-	IconDesc         string `json:"icon_desc"`          // 图标描述
-	IconDescColor    string `json:"icon_desc_color"`    // 图标描述颜色
-	WordScheme       string `json:"word_scheme"`        // 带格式的关键词
-	TopicFlag        int    `json:"topic_flag"`         // 话题标志
-	Word             string `json:"word"`               // 关键词
-	Rank             int    `json:"rank"`               // 排名
-	FlagDesc         string `json:"flag_desc"`          // 标志描述
-	Monitors         map[string]interface{} `json:"monitors,omitempty"` // 监控器
-	DotIcon          int    `json:"dot_icon,omitempty"` // 点图标
-	IsAd             int    `json:"is_ad,omitempty"`    // 是否广告
-	IconType         string `json:"icon_type,omitempty"` // 图标类型
-	ID               int    `json:"id,omitempty"`       // ID
+	Icon               string                 `json:"icon"`                  // 图标URL
+	IconWidth          int                    `json:"icon_width"`            // 图标宽度
+	IconHeight         int                    `json:"icon_height"`           // 图标高度
+	Emoticon           string                 `json:"emoticon"`              // 表情
+	Num                int64                  `json:"num"`                   // 热度值
+	Note               string                 `json:"note"`                  // 备注
+	RealPos            int                    `json:"realpos"`               // 实际排名
+	LabelName          string                 `json:"label_name"`            // 标签名称
+	SmallIconDesc      string                 `json:"small_icon_desc"`       // 小图标描述
+	SmallIconDescColor string                 `json:"small_icon_desc_color"` // 小图标描述颜色
+	Flag               int                    `json:"flag"`                  // 标志
+	IconDesc           string                 `json:"icon_desc"`             // 图标描述
+	IconDescColor      string                 `json:"icon_desc_color"`       // 图标描述颜色
+	WordScheme         string                 `json:"word_scheme"`           // 带格式的关键词
+	TopicFlag          int                    `json:"topic_flag"`            // 话题标志
+	Word               string                 `json:"word"`                  // 关键词
+	Rank               int                    `json:"rank"`                  // 排名
+	FlagDesc           string                 `json:"flag_desc"`             // 标志描述
+	Monitors           map[string]interface{} `json:"monitors,omitempty"`    // 监控器
+	DotIcon            int                    `json:"dot_icon,omitempty"`    // 点图标
+	IsAd               int                    `json:"is_ad,omitempty"`       // 是否广告
+	IconType           string                 `json:"icon_type,omitempty"`   // 图标类型
+	ID                 int                    `json:"id,omitempty"`          // ID
+
+	// 是否来自政府热搜
+	gov bool
 }
 
 // 微博API响应结构
 type weiboAPIResponse struct {
-	OK    int `json:"ok"`
-	Data  struct {
+	OK   int `json:"ok"`
+	Data struct {
 		Realtime []weiboHotSearchItem `json:"realtime"`
 		Hotgovs  []weiboHotSearchItem `json:"hotgovs"`
 		Hotgov   struct {
-			SmallIconDesc     string `json:"small_icon_desc"`
+			SmallIconDesc      string `json:"small_icon_desc"`
 			SmallIconDescColor string `json:"small_icon_desc_color"`
-			IconHeight        int    `json:"icon_height"`
-			IsHot            int    `json:"is_hot"`
-			Pos              int    `json:"pos"`
-			TopicFlag        int    `json:"topic_flag"`
-			Word             string `json:"word"`
-			IsGov            int    `json:"is_gov"`
-			Note             string `json:"note"`
-			Name             string `json:"name"`
-			URL              string `json:"url"`
-			Flag             int    `json:"flag"`
-			IconWidth        int    `json:"icon_width"`
-			Stime            int64  `json:"stime"`
-			IconDesc         string `json:"icon_desc"`
-			Icon             string `json:"icon"`
-			IconDescColor    string `json:"icon_desc_color"`
-			Mid              string `json:"mid"`
+			IconHeight         int    `json:"icon_height"`
+			IsHot              int    `json:"is_hot"`
+			Pos                int    `json:"pos"`
+			TopicFlag          int    `json:"topic_flag"`
+			Word               string `json:"word"`
+			IsGov              int    `json:"is_gov"`
+			Note               string `json:"note"`
+			Name               string `json:"name"`
+			URL                string `json:"url"`
+			Flag               int    `json:"flag"`
+			IconWidth          int    `json:"icon_width"`
+			Stime              int64  `json:"stime"`
+			IconDesc           string `json:"icon_desc"`
+			Icon               string `json:"icon"`
+			IconDescColor      string `json:"icon_desc_color"`
+			Mid                string `json:"mid"`
 		} `json:"hotgov"`
 		Logs struct {
 			ActCode int    `json:"act_code"`
@@ -92,27 +303,145 @@ type weiboAPIResponse struct {
 }
 
 func (widget *weiboWidget) initialize() error {
-	widget.withTitle("Weibo HotSearch").withCacheDuration(30 * time.Minute)
+	if err := widget.initializeHotSearch("Weibo HotSearch", widget.logger()); err != nil {
+		return err
+	}
+
+	if widget.ExcludeAdsRaw == nil {
+		widget.ExcludeAds = true
+	} else {
+		widget.ExcludeAds = *widget.ExcludeAdsRaw
+	}
+
+	widget.location = time.Local
+	if widget.Timezone != "" {
+		location, err := time.LoadLocation(widget.Timezone)
+		if err != nil {
+			return fmt.Errorf("invalid timezone '%s': %v", widget.Timezone, err)
+		}
+		widget.location = location
+	}
+
+	if err := validateRelativeLanguage(widget.RelativeLanguage); err != nil {
+		return err
+	}
+
+	widget.highlightTerms = nil
+	for _, term := range widget.Highlight {
+		if term = strings.TrimSpace(term); term != "" {
+			widget.highlightTerms = append(widget.highlightTerms, strings.ToLower(term))
+		}
+	}
+
+	if widget.IncludeGovRaw == nil {
+		widget.IncludeGov = true
+	} else {
+		widget.IncludeGov = *widget.IncludeGovRaw
+	}
+
+	if widget.NewTabRaw == nil {
+		widget.NewTab = true
+	} else {
+		widget.NewTab = *widget.NewTabRaw
+	}
+
+	if widget.APIURL == "" {
+		widget.APIURL = weiboAPIURL
+	}
+
+	if apiURL, err := url.Parse(widget.APIURL); err != nil || apiURL.Host == "" || (apiURL.Scheme != "http" && apiURL.Scheme != "https") {
+		return fmt.Errorf("invalid apiurl '%s', must be a http or https URL", widget.APIURL)
+	}
+
+	if widget.Retries == 0 {
+		widget.Retries = defaultWeiboRetries
+	}
+
+	if widget.MaxResponseBytes < 0 {
+		return fmt.Errorf("max-response-bytes must not be negative")
+	}
+	if widget.MaxResponseBytes == 0 {
+		widget.MaxResponseBytes = defaultMaxResponseBytes
+	}
+
+	if widget.EmptyMessage == "" {
+		widget.EmptyMessage = "没有符合筛选条件的热搜"
+	}
+
+	if widget.Charset != "" {
+		if _, err := htmlindex.Get(widget.Charset); err != nil {
+			return fmt.Errorf("unsupported charset '%s'", widget.Charset)
+		}
+	}
+
+	// categories 与 category 合并，属于其中任意一个类别的热搜都会展示
+	widget.includeCategories(widget.Categories)
+
+	switch widget.HotValueFormat {
+	case "":
+		widget.HotValueFormat = weiboHotValueFormatLatin
+	case weiboHotValueFormatLatin, weiboHotValueFormatChinese:
+	default:
+		return fmt.Errorf("hot-value-format must be one of: %s, %s", weiboHotValueFormatLatin, weiboHotValueFormatChinese)
+	}
+
+	switch widget.LinkStyle {
+	case "":
+		widget.LinkStyle = weiboLinkStyleDesktop
+	case weiboLinkStyleDesktop, weiboLinkStyleMobile:
+	default:
+		return fmt.Errorf("link-style must be one of: %s, %s", weiboLinkStyleDesktop, weiboLinkStyleMobile)
+	}
+
+	switch widget.SortBy {
+	case "":
+		widget.SortBy = weiboSortByNone
+	case weiboSortByNone, weiboSortByRank, weiboSortByNum, weiboSortByTrending:
+	default:
+		return fmt.Errorf("sort-by must be one of: %s, %s, %s, %s", weiboSortByRank, weiboSortByNum, weiboSortByTrending, weiboSortByNone)
+	}
 
-	// 优先使用limit字段，如果未设置则使用show-count
-	if widget.Limit > 0 {
-		widget.ShowCount = widget.Limit
+	if widget.GovWeightRaw == nil {
+		widget.GovWeight = 1
+	} else {
+		widget.GovWeight = *widget.GovWeightRaw
 	}
-	
-	if widget.ShowCount <= 0 {
-		widget.ShowCount = 10
+
+	if widget.GovWeight < 0 || widget.GovWeight > 1 {
+		return fmt.Errorf("gov-weight must be between 0 and 1")
+	}
+
+	if widget.HotPrecisionRaw == nil {
+		widget.HotPrecision = 1
+	} else {
+		widget.HotPrecision = *widget.HotPrecisionRaw
+	}
+
+	if widget.HotPrecision < 0 || widget.HotPrecision > 2 {
+		return fmt.Errorf("hot-precision must be between 0 and 2")
+	}
+
+	for i, item := range widget.StaticItems {
+		if strings.TrimSpace(item.Word) == "" {
+			return fmt.Errorf("static-items[%d] must have a word", i)
+		}
 	}
-	if widget.ShowCount > 50 {
-		widget.ShowCount = 50
+
+	if widget.SnapshotFile != "" {
+		widget.snapshotSink = &fileWeiboSnapshotSink{path: widget.SnapshotFile}
+	} else if widget.snapshotSink == nil {
+		widget.snapshotSink = noopWeiboSnapshotSink{}
 	}
-	
-	if widget.RefreshInterval <= 0 {
-		widget.RefreshInterval = 30 // 默认30分钟
+
+	// 只为展示的条目生成搜索链接，测试中可替换以统计生成次数
+	if widget.buildSearchURL == nil {
+		widget.buildSearchURL = widget.searchURL
+	}
+
+	if err := widget.loadExcludedCategories(); err != nil {
+		return err
 	}
 
-	// 设置缓存时间
-	widget.withCacheDuration(time.Duration(widget.RefreshInterval) * time.Minute)
-	
 	// 设置内容可用，确保Widget可以正常显示
 	widget.ContentAvailable = true
 
@@ -120,139 +449,942 @@ func (widget *weiboWidget) initialize() error {
 }
 
 func (widget *weiboWidget) update(ctx context.Context) {
-	// 获取微博热搜数据
-	hotSearches, err := widget.fetchWeiboHotSearch(ctx)
-	if err != nil {
-		widget.withError(err).scheduleEarlyUpdate()
+	// 接口持续失败时在退避期间内不再请求
+	if widget.breaker.isOpen() {
+		return
+	}
+
+	// 获取微博热搜数据，请求期间不持有锁
+	hotSearches, govHotSearches, err := widget.fetchWeiboHotSearch(ctx)
+
+	widget.mu.Lock()
+	if !widget.handleUpdateResult(err, widget.logger(), "Failed to fetch weibo hot search") {
+		widget.mu.Unlock()
 		return
 	}
 
+	widget.trackRankChanges(hotSearches)
 	widget.HotSearches = hotSearches
+	widget.GovHotSearches = govHotSearches
 	widget.LastUpdated = time.Now()
+	widget.recordActivity(hotSearches)
+	widget.CategoryCounts = countHotSearchCategories(hotSearches)
+	widget.Diversity = hotSearchDiversity(widget.CategoryCounts)
+	widget.updateHeadline()
+	widget.mu.Unlock()
+
+	widget.writeSnapshot(ctx, hotSearches)
+}
+
+// 按 timezone 格式化的上次更新时间，尚未更新时为空
+func (widget *weiboWidget) LastUpdatedIn() string {
+	if widget.LastUpdated.IsZero() {
+		return ""
+	}
+
+	return widget.LastUpdated.In(cmp.Or(widget.location, time.Local)).Format("2006-01-02 15:04 MST")
+}
+
+// 距离上次更新的相对时间，每次渲染时重新计算
+func (widget *weiboWidget) LastUpdatedRelative() string {
+	return formatRelativeTime(widget.LastUpdated, time.Now(), widget.RelativeLanguage)
+}
+
+// 热搜获取成功，但经过筛选后没有剩余条目
+func (widget *weiboWidget) IsFilteredEmpty() bool {
+	return len(widget.HotSearches) == 0 && !widget.LastUpdated.IsZero()
+}
+
+// 开启 feature-top 时将榜首单独作为头条展示
+func (widget *weiboWidget) updateHeadline() {
+	widget.Headline = nil
+
+	if widget.FeatureTop && len(widget.HotSearches) > 0 {
+		widget.Headline = &widget.HotSearches[0]
+	}
+}
+
+// 列表中展示的热搜，有头条时不包含头条
+func (widget *weiboWidget) ListedHotSearches() []weiboHotSearchEntry {
+	if widget.Headline != nil {
+		return widget.HotSearches[1:]
+	}
+
+	return widget.HotSearches
+}
+
+// 距离上次成功更新是否已超过 stale-after 设置的时间
+func (widget *weiboWidget) IsStale() bool {
+	if widget.StaleAfter <= 0 || widget.LastUpdated.IsZero() {
+		return false
+	}
+
+	return time.Since(widget.LastUpdated) > time.Duration(widget.StaleAfter)
+}
+
+// 计算类别分布的归一化熵，范围为0到1
+// 所有条目同属一个类别时为0，每个条目各属不同类别时为1
+func hotSearchDiversity(counts map[string]int) float64 {
+	var total int
+	for _, count := range counts {
+		total += count
+	}
+
+	if total <= 1 {
+		return 0
+	}
+
+	var entropy float64
+	for _, count := range counts {
+		if count == 0 {
+			continue
+		}
+		p := float64(count) / float64(total)
+		entropy -= p * math.Log(p)
+	}
+
+	return entropy / math.Log(float64(total))
+}
+
+// 多样性的百分比形式
+func (widget *weiboWidget) DiversityPercent() int {
+	return int(math.Round(widget.Diversity * 100))
+}
+
+// 统计榜单中各类别的条目数量，键为类别的显示名称
+func countHotSearchCategories(entries []weiboHotSearchEntry) map[string]int {
+	counts := make(map[string]int)
+
+	for i := range entries {
+		if entries[i].LabelName == "" {
+			continue
+		}
+		counts[entries[i].CategoryDisplayName()]++
+	}
+
+	return counts
+}
+
+// 记录当前展示榜单的总热度，最多保留固定数量的快照
+func (widget *weiboWidget) recordActivity(entries []weiboHotSearchEntry) {
+	var total int64
+	for i := range entries {
+		total += entries[i].Num
+	}
+
+	widget.ActivityHistory = append(widget.ActivityHistory, total)
+	if len(widget.ActivityHistory) > weiboActivityHistoryLength {
+		widget.ActivityHistory = widget.ActivityHistory[len(widget.ActivityHistory)-weiboActivityHistoryLength:]
+	}
+}
+
+// 当前榜单的总热度
+func (widget *weiboWidget) CurrentActivity() string {
+	if len(widget.ActivityHistory) == 0 {
+		return "0"
+	}
+
+	return formatWeiboHotValue(widget.ActivityHistory[len(widget.ActivityHistory)-1], *widget.hotValueStyle())
+}
+
+// 对比上一次更新时各关键词的排名，记录每条热搜的排名变化
+// 首次更新时没有可对比的数据，所有条目的变化都为空
+func (widget *weiboWidget) trackRankChanges(entries []weiboHotSearchEntry) {
+	ranks := make(map[string]int, len(entries))
+
+	for i := range entries {
+		entry := &entries[i]
+		if entry.Static {
+			continue
+		}
+
+		rank := entry.DisplayRank()
+		ranks[entry.Word] = rank
+
+		if widget.previousRanks == nil {
+			continue
+		}
+
+		previous, existed := widget.previousRanks[entry.Word]
+		entry.previousRank = previous
+
+		switch {
+		case !existed:
+			entry.rankDelta = weiboRankDeltaNew
+		case rank < previous:
+			entry.rankDelta = weiboRankDeltaUp
+		case rank > previous:
+			entry.rankDelta = weiboRankDeltaDown
+		default:
+			entry.rankDelta = weiboRankDeltaSame
+		}
+	}
+
+	widget.previousRanks = ranks
+}
+
+// 与上一次更新相比的排名变化：up、down、new、same，无法对比时为空
+func (entry *weiboHotSearchEntry) RankDelta() string {
+	return entry.rankDelta
+}
+
+// 上一次更新时的排名，新上榜或无法对比时为0
+func (entry *weiboHotSearchEntry) PreviousRank() int {
+	return entry.previousRank
+}
+
+// 与上一次快照相比总热度的变化趋势：up、down 或 flat
+func (widget *weiboWidget) ActivityTrend() string {
+	count := len(widget.ActivityHistory)
+	if count < 2 {
+		return "flat"
+	}
+
+	current, previous := widget.ActivityHistory[count-1], widget.ActivityHistory[count-2]
+	if current > previous {
+		return "up"
+	} else if current < previous {
+		return "down"
+	}
+
+	return "flat"
+}
+
+// 带有Widget类型和ID的日志记录器
+func (widget *weiboWidget) logger() *slog.Logger {
+	return slog.With("widget", "weibo", "widget_id", widget.ID)
 }
 
 func (widget *weiboWidget) Render() template.HTML {
+	widget.mu.Lock()
+	defer widget.mu.Unlock()
+
 	return widget.renderTemplate(widget, weiboWidgetTemplate)
 }
 
-// 获取微博热搜数据
-func (widget *weiboWidget) fetchWeiboHotSearch(ctx context.Context) ([]struct {
-	weiboHotSearchItem
-	URL string
-}, error) {
-	// 使用第三方API获取微博热搜数据
-	apiURL := "https://weibo.com/ajax/side/hotSearch"
-	
-	// 创建HTTP请求
-	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+// 处理组件请求，?format=json 时以JSON输出当前热搜，否则输出渲染后的HTML
+func (widget *weiboWidget) handleRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	widget.mu.Lock()
+	defer widget.mu.Unlock()
+
+	if r.URL.Query().Get("format") == "json" {
+		widget.writeJSON(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(widget.renderTemplate(widget, weiboWidgetTemplate)))
+}
+
+// JSON输出中的热搜条目
+type weiboHotSearchJSON struct {
+	Rank      int    `json:"rank"`
+	Word      string `json:"word"`
+	Category  string `json:"category,omitempty"`
+	HotValue  int64  `json:"hot_value"`
+	Formatted string `json:"formatted_hot_value"`
+	URL       string `json:"url"`
+}
+
+// 转换为JSON输出和快照使用的条目
+func hotSearchesJSON(entries []weiboHotSearchEntry) []weiboHotSearchJSON {
+	hotSearches := make([]weiboHotSearchJSON, 0, len(entries))
+	for i := range entries {
+		entry := &entries[i]
+		hotSearches = append(hotSearches, weiboHotSearchJSON{
+			Rank:      entry.DisplayRank(),
+			Word:      entry.Word,
+			Category:  entry.LabelName,
+			HotValue:  entry.Num,
+			Formatted: entry.FormattedHotValue(),
+			URL:       string(entry.URL),
+		})
+	}
+
+	return hotSearches
+}
+
+// 输出当前热搜和更新时间的JSON
+func (widget *weiboWidget) writeJSON(w http.ResponseWriter) {
+	hotSearches := hotSearchesJSON(widget.HotSearches)
+
+	response := struct {
+		OK          bool                 `json:"ok"`
+		Error       string               `json:"error,omitempty"`
+		LastUpdated time.Time            `json:"last_updated"`
+		HotSearches []weiboHotSearchJSON `json:"hot_searches"`
+	}{
+		OK:          widget.Error == nil,
+		LastUpdated: widget.LastUpdated,
+		HotSearches: hotSearches,
+	}
+
+	if widget.Error != nil {
+		response.Error = widget.Error.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// 每次成功更新后接收榜单快照，用于将数据持久化以便之后分析
+// 写入是同步进行的，实现需要在写入过程中检查 ctx，超时后尽快返回
+type weiboSnapshotSink interface {
+	Write(ctx context.Context, hotSearches []weiboHotSearchJSON) error
+}
+
+// 未配置快照时使用的空实现
+type noopWeiboSnapshotSink struct{}
+
+func (noopWeiboSnapshotSink) Write(context.Context, []weiboHotSearchJSON) error {
+	return nil
+}
+
+// 以JSON Lines格式将快照追加到文件，每次更新一行
+type fileWeiboSnapshotSink struct {
+	path string
+}
+
+func (sink *fileWeiboSnapshotSink) Write(ctx context.Context, hotSearches []weiboHotSearchJSON) error {
+	line, err := json.Marshal(struct {
+		Time        time.Time            `json:"time"`
+		HotSearches []weiboHotSearchJSON `json:"hot_searches"`
+	}{
+		Time:        time.Now(),
+		HotSearches: hotSearches,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(sink.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	// 写入中途失败或超时时截断到写入前的大小，避免留下不完整的一行
+	info, err := file.Stat()
 	if err != nil {
-		return nil, fmt.Errorf("创建请求失败: %v", err)
-	}
-	
-	// 设置请求头，模拟浏览器访问
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36")
-	req.Header.Set("Accept", "application/json, text/plain, */*")
-	req.Header.Set("Accept-Language", "zh-CN,zh;q=0.9,en;q=0.8")
-	req.Header.Set("Referer", "https://weibo.com")
-	
-	// 发送请求
-	client := &http.Client{}
-	resp, err := client.Do(req)
+		file.Close()
+		return err
+	}
+
+	if err := writeSnapshotChunks(ctx, file, append(line, '\n')); err != nil {
+		file.Truncate(info.Size())
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// 分块写入数据，每块写入之前检查 ctx，超时或取消后不再继续写入
+func writeSnapshotChunks(ctx context.Context, w io.Writer, data []byte) error {
+	for len(data) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		n, err := w.Write(data[:min(len(data), weiboSnapshotChunkSize)])
+		if err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+
+	return nil
+}
+
+// 将快照交给 sink 写入，最多用时固定时间，避免拖慢页面渲染
+// 写入在当前 goroutine 中进行，超时后 sink 停止写入，不会在后台继续运行
+func (widget *weiboWidget) writeSnapshot(ctx context.Context, entries []weiboHotSearchEntry) {
+	ctx, cancel := context.WithTimeout(ctx, weiboSnapshotWriteTimeout)
+	defer cancel()
+
+	err := widget.snapshotSink.Write(ctx, hotSearchesJSON(entries))
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		widget.logger().Warn("Writing hot search snapshot timed out", "timeout", weiboSnapshotWriteTimeout)
+	case err != nil:
+		widget.logger().Error("Failed to write hot search snapshot", "error", err)
+	}
+}
+
+// 获取微博热搜数据，同时返回开启 gov-section 时单独展示的政府热搜
+func (widget *weiboWidget) fetchWeiboHotSearch(ctx context.Context) ([]weiboHotSearchEntry, []weiboHotSearchEntry, error) {
+	apiResponse, err := widget.fetchWeiboAPIResponse(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return widget.processHotSearches(apiResponse), widget.processGovSection(apiResponse), nil
+}
+
+// 获取热搜并转换为通用的热榜条目，供 trending 组件聚合使用
+func (widget *weiboWidget) fetchHotSearchItems(ctx context.Context) ([]hotSearchItem, error) {
+	entries, _, err := widget.fetchWeiboHotSearch(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("请求失败: %v", err)
+		return nil, err
+	}
+
+	items := make([]hotSearchItem, 0, len(entries))
+	for i := range entries {
+		entry := &entries[i]
+		items = append(items, hotSearchItem{
+			Source:   "weibo",
+			Rank:     entry.DisplayRank(),
+			Title:    entry.DisplayWord(),
+			Category: entry.CategoryDisplayName(),
+			Heat:     entry.Num,
+			HeatText: entry.FormattedHotValue(),
+			URL:      entry.URL,
+		})
+	}
+
+	return items, nil
+}
+
+// 请求微博热搜接口，网络错误和5xx/429时按指数退避重试
+func (widget *weiboWidget) fetchWeiboAPIResponse(ctx context.Context) (*weiboAPIResponse, error) {
+	for attempt := 0; ; attempt++ {
+		apiResponse, retryable, err := widget.fetchWeiboAPIResponseOnce(ctx)
+		widget.stats.recordFetch(err)
+		if err == nil || !retryable || attempt >= widget.Retries {
+			return apiResponse, err
+		}
+
+		widget.logger().Debug("Retrying weibo hot search request", "attempt", attempt+1, "error", err)
+
+		if err := sleepWithContext(ctx, retryBackoffDelay(attempt)); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// 单次请求微博热搜接口，返回的布尔值表示错误是否可以重试
+func (widget *weiboWidget) fetchWeiboAPIResponseOnce(ctx context.Context) (*weiboAPIResponse, bool, error) {
+	// 在更新的context基础上附加请求超时，避免连接挂起时阻塞更新
+	requestCtx, cancel := context.WithTimeout(ctx, time.Duration(widget.Timeout))
+	defer cancel()
+
+	headers := widget.requestHeaders()
+	widget.logger().Debug("Requesting weibo hot search", "url", widget.APIURL, "headers", redactWeiboHeaders(headers))
+
+	// 发送请求，使用组件间共享的HTTP客户端
+	resp, err := fetchResponse(requestCtx, widget.httpClient(), "GET", widget.APIURL, headers, nil)
+	if err != nil {
+		// 外部context被取消时不再重试
+		return nil, isRetryableFetchError(ctx, err), fmt.Errorf("请求失败: %w", err)
 	}
 	defer resp.Body.Close()
-	
-	// 检查响应状态码
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API请求失败，状态码: %d", resp.StatusCode)
-	}
-	
-	// 读取响应内容
-	body, err := io.ReadAll(resp.Body)
+
+	// 读取响应内容，超过 max-response-bytes 时不再重试
+	body, err := readResponseBody(resp, widget.MaxResponseBytes)
 	if err != nil {
-		return nil, fmt.Errorf("读取响应内容失败: %v", err)
+		return nil, ctx.Err() == nil && !errors.Is(err, errResponseTooLarge), fmt.Errorf("读取响应内容失败: %w", err)
 	}
-	
+
+	// 非UTF-8的响应先转换为UTF-8
+	body, err = widget.decodeCharset(body, resp.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, false, fmt.Errorf("转换响应字符集失败: %v", err)
+	}
+
 	// 解析JSON响应
-	var apiResponse weiboAPIResponse
-	if err := json.Unmarshal(body, &apiResponse); err != nil {
-		return nil, fmt.Errorf("解析JSON响应失败: %v", err)
+	apiResponse, err := widget.decodeAPIResponse(body)
+	if err != nil {
+		return nil, false, fmt.Errorf("解析JSON响应失败: %w", newJSONDecodeError(resp.Header.Get("Content-Type"), body, err))
 	}
-	
+
 	// 检查API响应状态
 	if apiResponse.OK != 1 {
-		return nil, fmt.Errorf("API返回错误状态: ok=%d", apiResponse.OK)
+		return nil, false, fmt.Errorf("API返回错误状态: ok=%d", apiResponse.OK)
 	}
-	
-	// 合并实时热搜和政府热搜
+
+	return apiResponse, false, nil
+}
+
+// 请求热搜接口时使用的请求头，默认模拟浏览器访问，可以被 cookie 和 headers 覆盖
+func (widget *weiboWidget) requestHeaders() map[string]string {
+	headers := map[string]string{
+		"User-Agent":      cmp.Or(widget.UserAgent, weiboDefaultUserAgent),
+		"Accept":          "application/json, text/plain, */*",
+		"Accept-Language": "zh-CN,zh;q=0.9,en;q=0.8",
+		"Referer":         "https://weibo.com",
+	}
+
+	if widget.Cookie != "" {
+		headers["Cookie"] = widget.Cookie
+	}
+
+	// 统一请求头名称的大小写，保证自定义的值能覆盖默认值
+	for name, value := range widget.Headers {
+		headers[http.CanonicalHeaderKey(name)] = value
+	}
+
+	return headers
+}
+
+// 返回用于日志输出的请求头副本，隐去Cookie等凭据
+func redactWeiboHeaders(headers map[string]string) map[string]string {
+	redacted := maps.Clone(headers)
+
+	for _, name := range []string{"Cookie", "Authorization"} {
+		if redacted[name] != "" {
+			redacted[name] = "[redacted]"
+		}
+	}
+
+	return redacted
+}
+
+// 解析接口返回的JSON，非严格模式下忽略第一个JSON值之后的多余内容
+func (widget *weiboWidget) decodeAPIResponse(body []byte) (*weiboAPIResponse, error) {
+	var apiResponse weiboAPIResponse
+
+	if widget.StrictJSON {
+		if err := json.Unmarshal(body, &apiResponse); err != nil {
+			return nil, err
+		}
+		return &apiResponse, nil
+	}
+
+	if err := json.NewDecoder(bytes.NewReader(body)).Decode(&apiResponse); err != nil {
+		return nil, err
+	}
+
+	return &apiResponse, nil
+}
+
+// 合并直接配置的和从文件中加载的排除类别，文件不存在时仅记录警告
+// 类别统一按简称保存，与 categories 一样全称和简称都可以使用
+func (widget *weiboWidget) loadExcludedCategories() error {
+	widget.excludedCategories = make(map[string]struct{}, len(widget.ExcludeCategories))
+
+	for _, category := range widget.ExcludeCategories {
+		if category = strings.TrimSpace(category); category != "" {
+			widget.excludedCategories[hotSearchCategoryKey(category)] = struct{}{}
+		}
+	}
+
+	if widget.ExcludeCategoriesFile == "" {
+		return nil
+	}
+
+	contents, err := os.ReadFile(widget.ExcludeCategoriesFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			widget.logger().Warn("Exclude categories file does not exist", "file", widget.ExcludeCategoriesFile)
+			return nil
+		}
+		return fmt.Errorf("reading exclude-categories-file: %v", err)
+	}
+
+	for _, line := range strings.Split(string(contents), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			widget.excludedCategories[hotSearchCategoryKey(line)] = struct{}{}
+		}
+	}
+
+	return nil
+}
+
+// 按配置的字符集或 Content-Type 中声明的字符集将响应内容转换为UTF-8
+func (widget *weiboWidget) decodeCharset(body []byte, contentType string) ([]byte, error) {
+	name := widget.Charset
+	if name == "" {
+		if _, params, err := mime.ParseMediaType(contentType); err == nil {
+			name = params["charset"]
+		}
+	}
+
+	if name == "" || strings.EqualFold(name, "utf-8") || strings.EqualFold(name, "utf8") {
+		return body, nil
+	}
+
+	encoding, err := htmlindex.Get(name)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported charset '%s'", name)
+	}
+
+	return encoding.NewDecoder().Bytes(body)
+}
+
+// 过滤、截断热搜数据，仅为最终展示的条目生成链接
+func (widget *weiboWidget) processHotSearches(apiResponse *weiboAPIResponse) []weiboHotSearchEntry {
+	// 合并实时热搜和政府热搜，政府热搜单独分组时不合并
 	var allItems []weiboHotSearchItem
 	allItems = append(allItems, apiResponse.Data.Realtime...)
-	allItems = append(allItems, apiResponse.Data.Hotgovs...)
-	
-	// 过滤掉空数据
-	var filteredHotSearches []weiboHotSearchItem
-	for _, item := range allItems {
-		if item.Word != "" {
-			// 如果指定了类别过滤
-			if widget.Category != "" && item.LabelName != widget.Category {
-				continue
-			}
-			filteredHotSearches = append(filteredHotSearches, item)
-		}
+	if widget.IncludeGov && !widget.GovSection {
+		allItems = append(allItems, widget.govItems(apiResponse)...)
+	}
+
+	filteredHotSearches := widget.filterHotSearchItems(allItems)
+
+	// 排序在截断之前进行，保证展示的是排序后的前几条
+	switch widget.SortBy {
+	case weiboSortByNum:
+		slices.SortStableFunc(filteredHotSearches, func(a, b weiboHotSearchItem) int {
+			return cmp.Compare(b.Num, a.Num)
+		})
+	case weiboSortByRank:
+		slices.SortStableFunc(filteredHotSearches, func(a, b weiboHotSearchItem) int {
+			return cmp.Compare(a.Rank, b.Rank)
+		})
+	case weiboSortByTrending:
+		slices.SortStableFunc(filteredHotSearches, func(a, b weiboHotSearchItem) int {
+			// 加权热度相同时实时热搜排在前面
+			return cmp.Or(
+				cmp.Compare(widget.trendingHeat(&b), widget.trendingHeat(&a)),
+				cmp.Compare(ternary(a.gov, 1, 0), ternary(b.gov, 1, 0)),
+			)
+		})
 	}
-	
+
 	// 应用限制数量
-	if widget.ShowCount > 0 && len(filteredHotSearches) > widget.ShowCount {
-		filteredHotSearches = filteredHotSearches[:widget.ShowCount]
-	}
-	
-	// 为模板添加URL字段
-	var hotSearchesWithUrl []struct {
-		weiboHotSearchItem
-		URL string
-	}
-	for _, item := range filteredHotSearches {
-		searchURL := fmt.Sprintf("https://s.weibo.com/weibo?q=%s", url.QueryEscape(item.WordScheme))
-		hotSearchesWithUrl = append(hotSearchesWithUrl, struct {
-			weiboHotSearchItem
-			URL string
-		}{
+	filteredHotSearches = truncateHotSearches(&widget.hotSearchWidgetBase, filteredHotSearches)
+
+	// 固定条目排在最前面，不占用 ShowCount 的数量
+	hotSearchesWithUrl := make([]weiboHotSearchEntry, 0, len(widget.StaticItems)+len(filteredHotSearches))
+	for _, static := range widget.StaticItems {
+		item := weiboHotSearchItem{Word: static.Word, WordScheme: static.Word}
+		hotSearchesWithUrl = append(hotSearchesWithUrl, weiboHotSearchEntry{
 			weiboHotSearchItem: item,
-			URL:               searchURL,
+			URL:                safeURL(cmp.Or(static.URL, widget.buildSearchURL(&item))),
+			Static:             true,
 		})
 	}
-	
-	return hotSearchesWithUrl, nil
+
+	return append(hotSearchesWithUrl, widget.hotSearchEntries(filteredHotSearches)...)
+}
+
+// 开启 gov-section 时单独展示的政府热搜，同样经过筛选并最多展示 show-count 条
+func (widget *weiboWidget) processGovSection(apiResponse *weiboAPIResponse) []weiboHotSearchEntry {
+	if !widget.IncludeGov || !widget.GovSection {
+		return nil
+	}
+
+	items := widget.filterHotSearchItems(widget.govItems(apiResponse))
+	return widget.hotSearchEntries(truncateHotSearches(&widget.hotSearchWidgetBase, items))
+}
+
+// 标记为政府热搜的条目
+func (widget *weiboWidget) govItems(apiResponse *weiboAPIResponse) []weiboHotSearchItem {
+	items := make([]weiboHotSearchItem, 0, len(apiResponse.Data.Hotgovs))
+	for _, item := range apiResponse.Data.Hotgovs {
+		item.gov = true
+		items = append(items, item)
+	}
+
+	return items
+}
+
+// 过滤掉推广、与固定条目重复以及被排除的热搜，再按各热榜共用的条件筛选
+func (widget *weiboWidget) filterHotSearchItems(items []weiboHotSearchItem) []weiboHotSearchItem {
+	// 与固定条目重复的热搜不再出现在榜单中
+	staticWords := make(map[string]struct{}, len(widget.StaticItems))
+	for _, item := range widget.StaticItems {
+		staticWords[item.Word] = struct{}{}
+	}
+
+	var filteredHotSearches []weiboHotSearchItem
+	var removedAds int
+	for _, item := range items {
+		if _, isStatic := staticWords[item.Word]; isStatic {
+			continue
+		}
+		if widget.ExcludeAds && item.IsAd == 1 {
+			removedAds++
+			continue
+		}
+		if item.Num < widget.MinHotValue {
+			continue
+		}
+		if _, excluded := widget.excludedCategories[hotSearchCategoryKey(item.LabelName)]; excluded {
+			continue
+		}
+		filteredHotSearches = append(filteredHotSearches, item)
+	}
+
+	if removedAds > 0 {
+		widget.logger().Debug("Removed promoted hot searches", "count", removedAds)
+	}
+
+	return applyHotSearchFilters(&widget.hotSearchWidgetBase, filteredHotSearches)
+}
+
+func (item weiboHotSearchItem) filterTitle() string {
+	return item.Word
+}
+
+func (item weiboHotSearchItem) filterCategory() (string, bool) {
+	return item.LabelName, true
+}
+
+// 为筛选后的热搜添加链接和展示所需的字段
+func (widget *weiboWidget) hotSearchEntries(items []weiboHotSearchItem) []weiboHotSearchEntry {
+	hotValue := widget.hotValueStyle()
+
+	entries := make([]weiboHotSearchEntry, 0, len(items))
+	for i := range items {
+		entries = append(entries, weiboHotSearchEntry{
+			weiboHotSearchItem: items[i],
+			URL:                safeURL(widget.buildSearchURL(&items[i])),
+			Position:           i + 1,
+			hotValue:           hotValue,
+			showIcons:          widget.ShowIcons,
+			displayWord:        widget.displayWord(items[i].Word),
+			Highlighted:        widget.isHighlighted(items[i].Word),
+		})
+	}
+
+	// 筛选后实际排名可能大于 show-count，补零的宽度取决于列表中最大的排名
+	var maxRank int
+	for i := range entries {
+		maxRank = max(maxRank, entries[i].DisplayRank())
+	}
+
+	rankWidth := len(strconv.Itoa(maxRank))
+	for i := range entries {
+		entries[i].rankWidth = rankWidth
+	}
+
+	return entries
+}
+
+// 关键词是否包含任意一个关注的话题，不区分大小写
+func (widget *weiboWidget) isHighlighted(word string) bool {
+	if len(widget.highlightTerms) == 0 {
+		return false
+	}
+
+	word = strings.ToLower(word)
+
+	return slices.ContainsFunc(widget.highlightTerms, func(term string) bool {
+		return strings.Contains(word, term)
+	})
+}
+
+// 开启 clean-words 时返回整理后的关键词，否则为空
+func (widget *weiboWidget) displayWord(word string) string {
+	if !widget.CleanWords {
+		return ""
+	}
+
+	return cleanWeiboWord(word, widget.StripEmoji)
+}
+
+// 展示的关键词，整理后为空时使用原始关键词
+func (entry *weiboHotSearchEntry) DisplayWord() string {
+	return cmp.Or(entry.displayWord, entry.Word)
+}
+
+// 整理关键词：可选地去除表情符号，再去除首尾的#号并将连续的空白合并为一个空格
+func cleanWeiboWord(word string, stripEmoji bool) string {
+	if stripEmoji {
+		word = strings.Map(func(r rune) rune {
+			if isEmojiRune(r) {
+				return -1
+			}
+			return r
+		}, word)
+	}
+
+	word = strings.Trim(strings.TrimSpace(word), "#")
+
+	return strings.Join(strings.Fields(word), " ")
+}
+
+// 粗略判断字符是否属于表情符号，包括组成表情序列的连接符和变体选择符
+func isEmojiRune(r rune) bool {
+	switch {
+	case r >= 0x1F000 && r <= 0x1FAFF: // 表情、国旗、麻将和扑克牌等
+	case r >= 0x2600 && r <= 0x27BF: // 杂项符号和装饰符号
+	case r >= 0x2B00 && r <= 0x2BFF: // 箭头和星形等符号
+	case r >= 0xE0020 && r <= 0xE007F: // 旗帜序列使用的标签字符
+	case r == 0x200D || r == 0xFE0F || r == 0x20E3: // 零宽连接符、变体选择符和键帽组合符
+	default:
+		return false
+	}
+
+	return true
+}
+
+// 按 gov-weight 加权后的热度
+func (widget *weiboWidget) trendingHeat(item *weiboHotSearchItem) float64 {
+	if item.gov {
+		return float64(item.Num) * widget.GovWeight
+	}
+
+	return float64(item.Num)
+}
+
+// 开启 show-icons 时返回条目的图标地址，只接受HTTPS图片
+func (entry *weiboHotSearchEntry) IconURL() template.URL {
+	if !entry.showIcons || entry.Icon == "" {
+		return ""
+	}
+
+	// 接口返回的图标地址可能省略协议
+	icon := entry.Icon
+	if strings.HasPrefix(icon, "//") {
+		icon = "https:" + icon
+	}
+
+	iconURL, err := url.Parse(icon)
+	if err != nil || iconURL.Scheme != "https" || iconURL.Host == "" || iconURL.User != nil {
+		return ""
+	}
+
+	return template.URL(iconURL.String())
+}
+
+// 生成热搜关键词的微博搜索链接
+func (widget *weiboWidget) searchURL(item *weiboHotSearchItem) string {
+	// 很多实时热搜没有 word_scheme，此时使用关键词本身
+	query := cmp.Or(item.WordScheme, item.Word)
+	if widget.CleanQuery {
+		query = cleanWeiboQuery(query)
+	}
+
+	if widget.LinkStyle == weiboLinkStyleMobile {
+		// 移动版的搜索页面通过 containerid 传递搜索类型和关键词
+		return fmt.Sprintf("https://m.weibo.cn/search?containerid=%s", url.QueryEscape("100103type=1&q="+query))
+	}
+
+	return fmt.Sprintf("https://s.weibo.com/weibo?q=%s", url.QueryEscape(query))
+}
+
+// 清理搜索关键词：去除无效的UTF-8字符、首尾空白以及包裹话题的#号
+func cleanWeiboQuery(query string) string {
+	query = strings.ToValidUTF8(query, "")
+	query = strings.TrimSpace(query)
+	query = strings.Trim(query, "#")
+
+	return strings.TrimSpace(query)
+}
+
+// 展示的排名，接口未返回实际排名时使用在榜单中的位置
+func (entry *weiboHotSearchEntry) DisplayRank() int {
+	return cmp.Or(entry.RealPos, entry.Position)
+}
+
+// 补零到列表中最大排名宽度的排名，如最大排名为10时为 "01" ... "10"
+func (entry *weiboHotSearchEntry) RankPadded() string {
+	return fmt.Sprintf("%0*d", entry.rankWidth, entry.DisplayRank())
 }
 
 // 格式化热度值
-func (item *weiboHotSearchItem) FormattedHotValue() string {
-	if item.Num >= 1000000 {
-		return fmt.Sprintf("%.1fM", float64(item.Num)/1000000)
-	} else if item.Num >= 1000 {
-		return fmt.Sprintf("%.1fK", float64(item.Num)/1000)
+func (entry *weiboHotSearchEntry) FormattedHotValue() string {
+	if entry.hotValue == nil {
+		return formatWeiboHotValue(entry.Num, defaultWeiboHotValueStyle)
+	}
+
+	return formatWeiboHotValue(entry.Num, *entry.hotValue)
+}
+
+// 热度值的单位格式和小数位数
+type weiboHotValueStyle struct {
+	format    string
+	precision int
+}
+
+var defaultWeiboHotValueStyle = weiboHotValueStyle{format: weiboHotValueFormatLatin, precision: 1}
+
+func (widget *weiboWidget) hotValueStyle() *weiboHotValueStyle {
+	return &weiboHotValueStyle{format: widget.HotValueFormat, precision: widget.HotPrecision}
+}
+
+func formatWeiboHotValue(num int64, style weiboHotValueStyle) string {
+	if style.format == weiboHotValueFormatChinese {
+		if num >= 100000000 {
+			return strconv.FormatFloat(float64(num)/100000000, 'f', style.precision, 64) + "亿"
+		} else if num >= 10000 {
+			return strconv.FormatFloat(float64(num)/10000, 'f', style.precision, 64) + "万"
+		}
+		return strconv.FormatInt(num, 10)
+	}
+
+	if num >= 1000000 {
+		return strconv.FormatFloat(float64(num)/1000000, 'f', style.precision, 64) + "M"
+	} else if num >= 1000 {
+		return strconv.FormatFloat(float64(num)/1000, 'f', style.precision, 64) + "K"
+	}
+	return strconv.FormatInt(num, 10)
+}
+
+// 热搜的趋势标签，如 新、热、沸、爆
+type weiboTrendLabel struct {
+	Text  string
+	Class string
+}
+
+// 趋势标签文字到CSS类名的映射
+var weiboTrendLabelClasses = map[string]string{
+	"新": "weibo-trend-new",
+	"热": "weibo-trend-hot",
+	"沸": "weibo-trend-boil",
+	"爆": "weibo-trend-boom",
+}
+
+// 返回趋势标签，没有标签时返回nil
+func (item *weiboHotSearchItem) TrendLabel() *weiboTrendLabel {
+	text := strings.TrimSpace(cmp.Or(item.IconDesc, item.SmallIconDesc))
+	if text == "" {
+		return nil
+	}
+
+	class, ok := weiboTrendLabelClasses[text]
+	if !ok {
+		class = "weibo-trend-other"
+	}
+
+	return &weiboTrendLabel{Text: text, Class: class}
+}
+
+// 返回接口提供的标签颜色，不是合法的十六进制颜色时返回空字符串
+func (item *weiboHotSearchItem) TrendColor() string {
+	color := strings.TrimSpace(cmp.Or(item.IconDescColor, item.SmallIconDescColor))
+	if !hexColorPattern.MatchString(color) {
+		return ""
 	}
-	return strconv.FormatInt(item.Num, 10)
+
+	return color
 }
 
 // 获取类别显示名称
 func (item *weiboHotSearchItem) CategoryDisplayName() string {
-	categoryMap := map[string]string{
-		"娱乐":   "娱",
-		"社会":   "社",
-		"科技":   "科",
-		"体育":   "体",
-		"财经":   "财",
-		"热点":   "热",
-		"军事":   "军",
-		"国际":   "国",
-		"历史":   "史",
-		"美食":   "食",
-	}
-	
-	if name, ok := categoryMap[item.LabelName]; ok {
+	return weiboCategoryShortName(item.LabelName)
+}
+
+// 类别全称到简称的映射
+var weiboCategoryShortNames = map[string]string{
+	"娱乐": "娱",
+	"社会": "社",
+	"科技": "科",
+	"体育": "体",
+	"财经": "财",
+	"热点": "热",
+	"军事": "军",
+	"国际": "国",
+	"历史": "史",
+	"美食": "食",
+}
+
+// 返回类别的简称，没有对应简称时原样返回
+func weiboCategoryShortName(category string) string {
+	if name, ok := weiboCategoryShortNames[category]; ok {
 		return name
 	}
-	return item.LabelName
-}
\ No newline at end of file
+	return category
+}
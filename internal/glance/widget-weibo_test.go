@@ -0,0 +1,1321 @@
+package glance
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"io"
+	"log/slog"
+	"maps"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+func newTestWeiboWidget(t *testing.T) *weiboWidget {
+	t.Helper()
+
+	widget := &weiboWidget{}
+	if err := widget.initialize(); err != nil {
+		t.Fatalf("Failed to initialize weibo widget: %v", err)
+	}
+
+	return widget
+}
+
+func TestWeiboTickerRender(t *testing.T) {
+	widget := newTestWeiboWidget(t)
+	widget.HotSearches = []weiboHotSearchEntry{
+		{weiboHotSearchItem: weiboHotSearchItem{Word: "first", Num: 1500}, URL: "https://s.weibo.com/weibo?q=first"},
+		{weiboHotSearchItem: weiboHotSearchItem{Word: "second", Num: 20}, URL: "https://s.weibo.com/weibo?q=second"},
+	}
+
+	html := string(widget.Render())
+	if strings.Contains(html, "weibo-ticker") {
+		t.Fatal("Ticker markup should not be rendered by default")
+	}
+
+	widget.Ticker = true
+	html = string(widget.Render())
+
+	if !strings.Contains(html, `class="weibo-ticker"`) {
+		t.Fatalf("Expected ticker markup when ticker is enabled, got: %s", html)
+	}
+
+	if strings.Contains(html, "<ul") {
+		t.Fatal("List markup should not be rendered when ticker is enabled")
+	}
+
+	if !strings.Contains(html, "1.5K") {
+		t.Fatal("Expected ticker to include formatted hot value")
+	}
+}
+
+func newTestWeiboAPIResponse(items ...weiboHotSearchItem) *weiboAPIResponse {
+	response := &weiboAPIResponse{OK: 1}
+	response.Data.Realtime = items
+
+	return response
+}
+
+func TestWeiboDisplayedItemURLs(t *testing.T) {
+	widget := newTestWeiboWidget(t)
+	widget.ShowCount = 5
+
+	var items []weiboHotSearchItem
+	for i := range 200 {
+		word := "topic-" + strconv.Itoa(i)
+		items = append(items, weiboHotSearchItem{Word: word, WordScheme: "#" + word + "#", Rank: i})
+	}
+
+	built := 0
+	widget.buildSearchURL = func(item *weiboHotSearchItem) string {
+		built++
+		return widget.searchURL(item)
+	}
+
+	entries := widget.processHotSearches(newTestWeiboAPIResponse(items...))
+
+	if len(entries) != widget.ShowCount {
+		t.Fatalf("Expected %d entries, got %d", widget.ShowCount, len(entries))
+	}
+
+	if built != len(entries) {
+		t.Errorf("Expected %d URLs to be built, got %d", len(entries), built)
+	}
+
+	for i, entry := range entries {
+		word := "topic-" + strconv.Itoa(i)
+		if entry.Word != word {
+			t.Errorf("Expected entry %d to be %q, got %q", i, word, entry.Word)
+		}
+		if expected := "https://s.weibo.com/weibo?q=" + url.QueryEscape("#"+word+"#"); entry.URL != template.URL(expected) {
+			t.Errorf("Expected entry %q to link to %q, got %q", entry.Word, expected, entry.URL)
+		}
+	}
+}
+
+func TestWeiboActivityHistory(t *testing.T) {
+	widget := newTestWeiboWidget(t)
+	widget.ShowActivity = true
+
+	snapshot := func(nums ...int64) []weiboHotSearchEntry {
+		var entries []weiboHotSearchEntry
+		for _, num := range nums {
+			entries = append(entries, weiboHotSearchEntry{weiboHotSearchItem: weiboHotSearchItem{Num: num}})
+		}
+		return entries
+	}
+
+	widget.recordActivity(snapshot(100, 200))
+	if trend := widget.ActivityTrend(); trend != "flat" {
+		t.Errorf("Expected flat trend with a single snapshot, got %s", trend)
+	}
+
+	widget.recordActivity(snapshot(500, 100))
+	if trend := widget.ActivityTrend(); trend != "up" {
+		t.Errorf("Expected up trend, got %s", trend)
+	}
+
+	widget.recordActivity(snapshot(50))
+	if trend := widget.ActivityTrend(); trend != "down" {
+		t.Errorf("Expected down trend, got %s", trend)
+	}
+
+	expected := []int64{300, 600, 50}
+	if !slices.Equal(widget.ActivityHistory, expected) {
+		t.Errorf("Expected activity history %v, got %v", expected, widget.ActivityHistory)
+	}
+
+	for i := range weiboActivityHistoryLength * 2 {
+		widget.recordActivity(snapshot(int64(i)))
+	}
+
+	if len(widget.ActivityHistory) != weiboActivityHistoryLength {
+		t.Errorf("Expected activity history to be capped at %d, got %d", weiboActivityHistoryLength, len(widget.ActivityHistory))
+	}
+
+	if last := widget.ActivityHistory[len(widget.ActivityHistory)-1]; last != weiboActivityHistoryLength*2-1 {
+		t.Errorf("Expected the latest snapshot to be kept, got %d", last)
+	}
+}
+
+func TestWeiboCategoryCounts(t *testing.T) {
+	widget := newTestWeiboWidget(t)
+
+	entries := widget.processHotSearches(newTestWeiboAPIResponse(
+		weiboHotSearchItem{Word: "a", LabelName: "娱乐"},
+		weiboHotSearchItem{Word: "b", LabelName: "社会"},
+		weiboHotSearchItem{Word: "c", LabelName: "娱乐"},
+		weiboHotSearchItem{Word: "d", LabelName: "新"},
+		weiboHotSearchItem{Word: "e"},
+		weiboHotSearchItem{LabelName: "娱乐"},
+	))
+
+	counts := countHotSearchCategories(entries)
+	expected := map[string]int{"娱": 2, "社": 1, "新": 1}
+
+	if !maps.Equal(counts, expected) {
+		t.Errorf("Expected category counts %v, got %v", expected, counts)
+	}
+}
+
+func TestWeiboIsStale(t *testing.T) {
+	widget := newTestWeiboWidget(t)
+
+	widget.LastUpdated = time.Now().Add(-time.Hour)
+	if widget.IsStale() {
+		t.Error("Widget should never be stale when stale-after is not set")
+	}
+
+	widget.StaleAfter = durationField(10 * time.Minute)
+
+	widget.LastUpdated = time.Now().Add(-5 * time.Minute)
+	if widget.IsStale() {
+		t.Error("Widget should not be stale before the threshold")
+	}
+
+	widget.LastUpdated = time.Now().Add(-15 * time.Minute)
+	if !widget.IsStale() {
+		t.Error("Widget should be stale after the threshold")
+	}
+
+	if !strings.Contains(string(widget.Render()), "weibo-stale") {
+		t.Error("Expected stale indicator to be rendered")
+	}
+}
+
+func TestWeiboHeadline(t *testing.T) {
+	widget := newTestWeiboWidget(t)
+	widget.HotSearches = widget.processHotSearches(newTestWeiboAPIResponse(
+		weiboHotSearchItem{Word: "top"},
+		weiboHotSearchItem{Word: "second"},
+		weiboHotSearchItem{Word: "third"},
+	))
+
+	widget.updateHeadline()
+	if widget.Headline != nil {
+		t.Error("Expected no headline when feature-top is disabled")
+	}
+	if listed := widget.ListedHotSearches(); len(listed) != 3 || listed[0].Word != "top" {
+		t.Errorf("Expected list to include the top item when feature-top is disabled, got %v", listed)
+	}
+
+	widget.FeatureTop = true
+	widget.updateHeadline()
+	if widget.Headline == nil || widget.Headline.Word != "top" {
+		t.Fatalf("Expected headline to be the top item, got %v", widget.Headline)
+	}
+	if listed := widget.ListedHotSearches(); len(listed) != 2 || listed[0].Word != "second" {
+		t.Errorf("Expected list to exclude the headline, got %v", listed)
+	}
+
+	widget.HotSearches = nil
+	widget.updateHeadline()
+	if widget.Headline != nil {
+		t.Error("Expected no headline for an empty board")
+	}
+}
+
+func TestWeiboDecodeWithTrailingGarbage(t *testing.T) {
+	body := []byte(`{"ok":1,"data":{"realtime":[{"word":"topic","num":100}]}}` + "\n<!-- cache: hit -->garbage")
+
+	widget := newTestWeiboWidget(t)
+	response, err := widget.decodeAPIResponse(body)
+	if err != nil {
+		t.Fatalf("Expected leading JSON to be decoded, got error: %v", err)
+	}
+
+	if response.OK != 1 || len(response.Data.Realtime) != 1 || response.Data.Realtime[0].Word != "topic" {
+		t.Errorf("Unexpected decoded response: %+v", response)
+	}
+
+	widget.StrictJSON = true
+	if _, err := widget.decodeAPIResponse(body); err == nil {
+		t.Error("Expected strict decoding to reject trailing data")
+	}
+}
+
+func TestWeiboCleanQuery(t *testing.T) {
+	widget := newTestWeiboWidget(t)
+	item := weiboHotSearchItem{Word: "春节档票房", WordScheme: " #春节档票房# "}
+
+	if url := widget.searchURL(&item); url != "https://s.weibo.com/weibo?q=+%23%E6%98%A5%E8%8A%82%E6%A1%A3%E7%A5%A8%E6%88%BF%23+" {
+		t.Errorf("Expected raw scheme to be escaped as is when clean-query is disabled, got %s", url)
+	}
+
+	widget.CleanQuery = true
+	entries := widget.processHotSearches(newTestWeiboAPIResponse(item))
+
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 entry, got %d", len(entries))
+	}
+
+	if entries[0].URL != "https://s.weibo.com/weibo?q=%E6%98%A5%E8%8A%82%E6%A1%A3%E7%A5%A8%E6%88%BF" {
+		t.Errorf("Expected URL to use the cleaned query, got %s", entries[0].URL)
+	}
+
+	if entries[0].WordScheme != item.WordScheme {
+		t.Errorf("Expected raw word scheme %q to be kept, got %q", item.WordScheme, entries[0].WordScheme)
+	}
+
+	widget.HotSearches = entries
+	if html := string(widget.Render()); !strings.Contains(html, item.Word) {
+		t.Errorf("Expected the original word to be displayed, got: %s", html)
+	}
+}
+
+func TestWeiboExcludeCategoriesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "exclude.txt")
+	if err := os.WriteFile(path, []byte("# 不想看的类别\n娱乐\n\n 综艺 \n"), 0o644); err != nil {
+		t.Fatalf("Failed to write exclude categories file: %v", err)
+	}
+
+	widget := &weiboWidget{ExcludeCategories: []string{"体育"}, ExcludeCategoriesFile: path}
+	if err := widget.initialize(); err != nil {
+		t.Fatalf("Failed to initialize weibo widget: %v", err)
+	}
+
+	entries := widget.processHotSearches(newTestWeiboAPIResponse(
+		weiboHotSearchItem{Word: "a", LabelName: "娱乐"},
+		weiboHotSearchItem{Word: "b", LabelName: "社会"},
+		weiboHotSearchItem{Word: "c", LabelName: "综艺"},
+		weiboHotSearchItem{Word: "d", LabelName: "体育"},
+		weiboHotSearchItem{Word: "e"},
+	))
+
+	var words []string
+	for _, entry := range entries {
+		words = append(words, entry.Word)
+	}
+
+	if expected := []string{"b", "e"}; !slices.Equal(words, expected) {
+		t.Errorf("Expected words %v after excluding categories, got %v", expected, words)
+	}
+
+	short := &weiboWidget{ExcludeCategories: []string{"娱", "体育"}}
+	if err := short.initialize(); err != nil {
+		t.Fatalf("Failed to initialize weibo widget: %v", err)
+	}
+
+	words = nil
+	for _, entry := range short.processHotSearches(newTestWeiboAPIResponse(
+		weiboHotSearchItem{Word: "a", LabelName: "娱乐"},
+		weiboHotSearchItem{Word: "b", LabelName: "社会"},
+		weiboHotSearchItem{Word: "c", LabelName: "体育"},
+	)) {
+		words = append(words, entry.Word)
+	}
+
+	if expected := []string{"b"}; !slices.Equal(words, expected) {
+		t.Errorf("Expected words %v after excluding categories by short name, got %v", expected, words)
+	}
+
+	missing := &weiboWidget{ExcludeCategoriesFile: filepath.Join(t.TempDir(), "missing.txt")}
+	if err := missing.initialize(); err != nil {
+		t.Errorf("Expected missing exclude categories file to be ignored, got error: %v", err)
+	}
+}
+
+func TestWeiboDecodeGBKCharset(t *testing.T) {
+	body, err := simplifiedchinese.GBK.NewEncoder().Bytes([]byte(`{"ok":1,"data":{"realtime":[{"word":"春节档票房","label_name":"娱乐"}]}}`))
+	if err != nil {
+		t.Fatalf("Failed to encode GBK body: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		charset     string
+		contentType string
+	}{
+		{"override", "gbk", "application/json"},
+		{"content-type", "", "application/json; charset=GBK"},
+	}
+
+	for _, test := range tests {
+		widget := &weiboWidget{Charset: test.charset}
+		if err := widget.initialize(); err != nil {
+			t.Fatalf("%s: failed to initialize weibo widget: %v", test.name, err)
+		}
+
+		decoded, err := widget.decodeCharset(body, test.contentType)
+		if err != nil {
+			t.Fatalf("%s: failed to decode charset: %v", test.name, err)
+		}
+
+		response, err := widget.decodeAPIResponse(decoded)
+		if err != nil {
+			t.Fatalf("%s: failed to decode response: %v", test.name, err)
+		}
+
+		item := response.Data.Realtime[0]
+		if item.Word != "春节档票房" || item.LabelName != "娱乐" {
+			t.Errorf("%s: expected GBK body to be decoded, got word %q and label %q", test.name, item.Word, item.LabelName)
+		}
+	}
+
+	if err := (&weiboWidget{Charset: "not-a-charset"}).initialize(); err == nil {
+		t.Error("Expected unknown charset to fail at initialize")
+	}
+}
+
+func TestWeiboRankPadded(t *testing.T) {
+	var tenItems []weiboHotSearchItem
+	for i := range 10 {
+		tenItems = append(tenItems, weiboHotSearchItem{Word: strconv.Itoa(i)})
+	}
+
+	tests := []struct {
+		name      string
+		showCount int
+		items     []weiboHotSearchItem
+		expected  []string
+	}{
+		{
+			name:      "single digit ranks",
+			showCount: 10,
+			items:     []weiboHotSearchItem{{Word: "a", RealPos: 1}, {Word: "b", RealPos: 2}, {Word: "c"}},
+			expected:  []string{"1", "2", "3"},
+		},
+		{
+			name:      "ten positions",
+			showCount: 10,
+			items:     tenItems,
+			expected:  []string{"01", "02", "03", "04", "05", "06", "07", "08", "09", "10"},
+		},
+		{
+			name:      "filtered real positions above show-count",
+			showCount: 9,
+			items: []weiboHotSearchItem{
+				{Word: "a", RealPos: 3},
+				{Word: "blocked", RealPos: 4},
+				{Word: "b", RealPos: 12},
+				{Word: "c", RealPos: 45},
+			},
+			expected: []string{"03", "12", "45"},
+		},
+	}
+
+	for _, test := range tests {
+		widget := &weiboWidget{hotSearchWidgetBase: hotSearchWidgetBase{ShowCount: test.showCount, Blocklist: []string{"blocked"}}}
+		if err := widget.initialize(); err != nil {
+			t.Fatalf("Failed to initialize weibo widget: %v", err)
+		}
+
+		entries := widget.processHotSearches(newTestWeiboAPIResponse(test.items...))
+
+		var ranks []string
+		for i := range entries {
+			ranks = append(ranks, entries[i].RankPadded())
+		}
+
+		if !slices.Equal(ranks, test.expected) {
+			t.Errorf("%s: expected ranks %v, got %v", test.name, test.expected, ranks)
+		}
+	}
+}
+
+func TestWeiboStaticItems(t *testing.T) {
+	widget := &weiboWidget{
+		hotSearchWidgetBase: hotSearchWidgetBase{ShowCount: 2},
+		StaticItems: []weiboStaticItem{
+			{Word: "公司公告", URL: "https://example.com/announcement"},
+			{Word: "live"},
+		},
+	}
+	if err := widget.initialize(); err != nil {
+		t.Fatalf("Failed to initialize weibo widget: %v", err)
+	}
+
+	entries := widget.processHotSearches(newTestWeiboAPIResponse(
+		weiboHotSearchItem{Word: "live", WordScheme: "#live#", Num: 100},
+		weiboHotSearchItem{Word: "a", Num: 90},
+		weiboHotSearchItem{Word: "b", Num: 80},
+	))
+
+	var words []string
+	for _, entry := range entries {
+		words = append(words, entry.Word)
+	}
+
+	if expected := []string{"公司公告", "live", "a", "b"}; !slices.Equal(words, expected) {
+		t.Fatalf("Expected words %v, got %v", expected, words)
+	}
+
+	if !entries[0].Static || !entries[1].Static || entries[2].Static {
+		t.Error("Expected only the leading entries to be static")
+	}
+
+	if entries[0].URL != "https://example.com/announcement" {
+		t.Errorf("Expected static item to use its configured URL, got %s", entries[0].URL)
+	}
+
+	if entries[1].URL != "https://s.weibo.com/weibo?q=live" {
+		t.Errorf("Expected static item without URL to link to a search, got %s", entries[1].URL)
+	}
+
+	widget.StaticItems = []weiboStaticItem{{Word: "xss", URL: "javascript:alert(1)"}}
+	entries = widget.processHotSearches(newTestWeiboAPIResponse())
+
+	if entries[0].URL != "" {
+		t.Errorf("Expected static item with a javascript: URL to have no link, got %s", entries[0].URL)
+	}
+}
+
+func TestWeiboHotPrecision(t *testing.T) {
+	tests := []struct {
+		format    string
+		precision int
+		num       int64
+		expected  string
+	}{
+		{weiboHotValueFormatLatin, 0, 1234567, "1M"},
+		{weiboHotValueFormatLatin, 2, 1234567, "1.23M"},
+		{weiboHotValueFormatLatin, 0, 15600, "16K"},
+		{weiboHotValueFormatLatin, 2, 15600, "15.60K"},
+		{weiboHotValueFormatChinese, 0, 12345678, "1235万"},
+		{weiboHotValueFormatChinese, 2, 12345678, "1234.57万"},
+		{weiboHotValueFormatChinese, 2, 123456789, "1.23亿"},
+		{weiboHotValueFormatLatin, 2, 999, "999"},
+	}
+
+	for _, test := range tests {
+		precision := test.precision
+		widget := &weiboWidget{HotValueFormat: test.format, HotPrecisionRaw: &precision}
+		if err := widget.initialize(); err != nil {
+			t.Fatalf("Failed to initialize weibo widget: %v", err)
+		}
+
+		entries := widget.processHotSearches(newTestWeiboAPIResponse(weiboHotSearchItem{Word: "a", Num: test.num}))
+		if formatted := entries[0].FormattedHotValue(); formatted != test.expected {
+			t.Errorf("%s with precision %d: expected %d to be formatted as %q, got %q", test.format, test.precision, test.num, test.expected, formatted)
+		}
+	}
+
+	precision := 3
+	if err := (&weiboWidget{HotPrecisionRaw: &precision}).initialize(); err == nil {
+		t.Error("Expected hot-precision above 2 to fail at initialize")
+	}
+}
+
+func TestWeiboDiversity(t *testing.T) {
+	balanced := hotSearchDiversity(map[string]int{"娱": 3, "社": 3, "科": 2, "体": 2})
+	skewed := hotSearchDiversity(map[string]int{"娱": 8, "社": 1, "科": 1})
+
+	if balanced <= skewed {
+		t.Errorf("Expected balanced board to be more diverse than skewed board, got %f <= %f", balanced, skewed)
+	}
+
+	if single := hotSearchDiversity(map[string]int{"娱": 10}); single != 0 {
+		t.Errorf("Expected board with a single category to have zero diversity, got %f", single)
+	}
+
+	if distinct := hotSearchDiversity(map[string]int{"娱": 1, "社": 1, "科": 1}); math.Abs(distinct-1) > 1e-9 {
+		t.Errorf("Expected board with distinct categories to have full diversity, got %f", distinct)
+	}
+}
+
+func TestWeiboEmptyMessage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(newTestWeiboAPIResponse(
+			weiboHotSearchItem{Word: "明星八卦", Num: 100},
+			weiboHotSearchItem{Word: "八卦新闻", Num: 90},
+		))
+	}))
+	defer server.Close()
+
+	widget := &weiboWidget{
+		hotSearchWidgetBase: hotSearchWidgetBase{Blocklist: []string{"八卦"}},
+		APIURL:              server.URL,
+		EmptyMessage:        "今天没有想看的热搜",
+	}
+	if err := widget.initialize(); err != nil {
+		t.Fatalf("Failed to initialize weibo widget: %v", err)
+	}
+
+	if html := string(widget.Render()); strings.Contains(html, widget.EmptyMessage) {
+		t.Error("Expected empty message not to render before the first fetch")
+	}
+
+	widget.update(context.Background())
+
+	if len(widget.HotSearches) != 0 {
+		t.Fatalf("Expected filters to remove all hot searches, got %d", len(widget.HotSearches))
+	}
+
+	html := string(widget.Render())
+	if !strings.Contains(html, widget.EmptyMessage) {
+		t.Error("Expected empty message to render when filters remove all hot searches")
+	}
+
+	if strings.Contains(html, "暂无热搜数据") {
+		t.Error("Expected filtered board not to render the no data message")
+	}
+}
+
+func TestWeiboGovWeight(t *testing.T) {
+	response := newTestWeiboAPIResponse(
+		weiboHotSearchItem{Word: "a", Num: 1000},
+		weiboHotSearchItem{Word: "b", Num: 500},
+		weiboHotSearchItem{Word: "c", Num: 100},
+	)
+	response.Data.Hotgovs = []weiboHotSearchItem{{Word: "gov", Num: 800}}
+
+	tests := []struct {
+		weight   float64
+		expected []string
+	}{
+		{1, []string{"a", "gov", "b", "c"}},
+		{0.5, []string{"a", "b", "gov", "c"}},
+		{0, []string{"a", "b", "c", "gov"}},
+	}
+
+	for _, test := range tests {
+		widget := &weiboWidget{SortBy: weiboSortByTrending, GovWeightRaw: &test.weight}
+		if err := widget.initialize(); err != nil {
+			t.Fatalf("Failed to initialize weibo widget: %v", err)
+		}
+
+		var words []string
+		for _, entry := range widget.processHotSearches(response) {
+			words = append(words, entry.Word)
+		}
+
+		if !slices.Equal(words, test.expected) {
+			t.Errorf("Expected order %v with gov-weight %v, got %v", test.expected, test.weight, words)
+		}
+	}
+
+	invalid := 1.5
+	if err := (&weiboWidget{GovWeightRaw: &invalid}).initialize(); err == nil {
+		t.Error("Expected gov-weight above 1 to be rejected")
+	}
+}
+
+type recordingWeiboSnapshotSink struct {
+	snapshots [][]weiboHotSearchJSON
+}
+
+func (sink *recordingWeiboSnapshotSink) Write(_ context.Context, hotSearches []weiboHotSearchJSON) error {
+	sink.snapshots = append(sink.snapshots, hotSearches)
+	return nil
+}
+
+func TestWeiboSnapshotSink(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(newTestWeiboAPIResponse(
+			weiboHotSearchItem{Word: "a", Num: 100},
+			weiboHotSearchItem{Word: "b", Num: 90},
+		))
+	}))
+	defer server.Close()
+
+	sink := &recordingWeiboSnapshotSink{}
+	widget := &weiboWidget{APIURL: server.URL, snapshotSink: sink}
+	if err := widget.initialize(); err != nil {
+		t.Fatalf("Failed to initialize weibo widget: %v", err)
+	}
+
+	widget.update(context.Background())
+	widget.update(context.Background())
+
+	if len(sink.snapshots) != 2 {
+		t.Fatalf("Expected a snapshot for each update, got %d", len(sink.snapshots))
+	}
+
+	if snapshot := sink.snapshots[1]; len(snapshot) != 2 || snapshot[0].Word != "a" || snapshot[1].Rank != 2 {
+		t.Errorf("Unexpected snapshot contents: %+v", snapshot)
+	}
+}
+
+// 写入第一块后取消 ctx 的 Writer
+type cancellingWriter struct {
+	cancel context.CancelFunc
+	writes int
+}
+
+func (w *cancellingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	w.cancel()
+	return len(p), nil
+}
+
+func TestWeiboSnapshotWriteStopsOnTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	writer := &cancellingWriter{cancel: cancel}
+
+	err := writeSnapshotChunks(ctx, writer, make([]byte, weiboSnapshotChunkSize*3))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected writing to stop with context.Canceled, got %v", err)
+	}
+	if writer.writes != 1 {
+		t.Errorf("Expected a single chunk to be written before stopping, got %d", writer.writes)
+	}
+
+	path := filepath.Join(t.TempDir(), "snapshots.jsonl")
+	if err := os.WriteFile(path, []byte("{}\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write snapshot file: %v", err)
+	}
+
+	sink := &fileWeiboSnapshotSink{path: path}
+	if err := sink.Write(ctx, []weiboHotSearchJSON{{Rank: 1, Word: "a"}}); err == nil {
+		t.Error("Expected writing with an expired context to fail")
+	}
+
+	if contents, _ := os.ReadFile(path); string(contents) != "{}\n" {
+		t.Errorf("Expected the snapshot file to be left unchanged, got %q", contents)
+	}
+}
+
+func TestWeiboCircuitBreaker(t *testing.T) {
+	var requests atomic.Int32
+	var fail atomic.Bool
+	fail.Store(true)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if fail.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(newTestWeiboAPIResponse(weiboHotSearchItem{Word: "a", Num: 100}))
+	}))
+	defer server.Close()
+
+	widget := &weiboWidget{APIURL: server.URL, Retries: -1}
+	if err := widget.initialize(); err != nil {
+		t.Fatalf("Failed to initialize weibo widget: %v", err)
+	}
+
+	for range circuitBreakerThreshold + 1 {
+		widget.update(context.Background())
+	}
+
+	if requests.Load() != circuitBreakerThreshold {
+		t.Fatalf("Expected no requests while the breaker is open, got %d requests", requests.Load())
+	}
+
+	if until := time.Until(widget.nextUpdate); until < circuitBreakerBaseDelay-time.Minute {
+		t.Errorf("Expected next update to be delayed by the breaker, got %s", until)
+	}
+
+	// 退避结束后第一次成功即重置
+	fail.Store(false)
+	widget.breaker.openUntil = time.Time{}
+	widget.update(context.Background())
+
+	if widget.breaker.failures != 0 || len(widget.HotSearches) != 1 {
+		t.Errorf("Expected a successful update to reset the breaker, got %d failures", widget.breaker.failures)
+	}
+}
+
+func TestWeiboEarlyUpdateBackoff(t *testing.T) {
+	var fail atomic.Bool
+	fail.Store(true)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(newTestWeiboAPIResponse(weiboHotSearchItem{Word: "a", Num: 100}))
+	}))
+	defer server.Close()
+
+	widget := &weiboWidget{APIURL: server.URL, Retries: -1}
+	if err := widget.initialize(); err != nil {
+		t.Fatalf("Failed to initialize weibo widget: %v", err)
+	}
+
+	widget.update(context.Background())
+	first := time.Until(widget.nextUpdate)
+	widget.update(context.Background())
+	second := time.Until(widget.nextUpdate)
+
+	if second <= first {
+		t.Errorf("Expected early updates to be spaced further apart, got %s then %s", first, second)
+	}
+
+	fail.Store(false)
+	widget.update(context.Background())
+
+	if widget.Error != nil || widget.updateRetriedTimes != 0 {
+		t.Errorf("Expected a successful update to clear the error and failure count, got %v and %d", widget.Error, widget.updateRetriedTimes)
+	}
+
+	if until := time.Until(widget.nextUpdate); until < 29*time.Minute {
+		t.Errorf("Expected the next update to use the refresh interval, got %s", until)
+	}
+}
+
+func TestWeiboGovSection(t *testing.T) {
+	response := newTestWeiboAPIResponse(weiboHotSearchItem{Word: "realtime", Num: 1000})
+	response.Data.Hotgovs = []weiboHotSearchItem{{Word: "gov", Num: 800}}
+
+	words := func(entries []weiboHotSearchEntry) []string {
+		var words []string
+		for _, entry := range entries {
+			words = append(words, entry.Word)
+		}
+		return words
+	}
+
+	excluded := false
+	tests := []struct {
+		widget   *weiboWidget
+		expected []string
+		gov      []string
+	}{
+		{&weiboWidget{}, []string{"realtime", "gov"}, nil},
+		{&weiboWidget{IncludeGovRaw: &excluded}, []string{"realtime"}, nil},
+		{&weiboWidget{GovSection: true}, []string{"realtime"}, []string{"gov"}},
+		{&weiboWidget{IncludeGovRaw: &excluded, GovSection: true}, []string{"realtime"}, nil},
+	}
+
+	for _, test := range tests {
+		if err := test.widget.initialize(); err != nil {
+			t.Fatalf("Failed to initialize weibo widget: %v", err)
+		}
+
+		if actual := words(test.widget.processHotSearches(response)); !slices.Equal(actual, test.expected) {
+			t.Errorf("Expected hot searches %v, got %v", test.expected, actual)
+		}
+
+		if actual := words(test.widget.processGovSection(response)); !slices.Equal(actual, test.gov) {
+			t.Errorf("Expected government section %v, got %v", test.gov, actual)
+		}
+	}
+
+	widget := tests[2].widget
+	widget.HotSearches = widget.processHotSearches(response)
+	widget.GovHotSearches = widget.processGovSection(response)
+	if html := string(widget.Render()); !strings.Contains(html, "weibo-gov-section") {
+		t.Error("Expected government section to be rendered")
+	}
+}
+
+func TestWeiboSearchURLWithoutWordScheme(t *testing.T) {
+	widget := newTestWeiboWidget(t)
+
+	entries := widget.processHotSearches(newTestWeiboAPIResponse(
+		weiboHotSearchItem{Word: "春节档票房", Num: 100},
+		weiboHotSearchItem{Word: "a b", WordScheme: "#a b#", Num: 90},
+	))
+
+	expected := []template.URL{
+		"https://s.weibo.com/weibo?q=%E6%98%A5%E8%8A%82%E6%A1%A3%E7%A5%A8%E6%88%BF",
+		"https://s.weibo.com/weibo?q=%23a+b%23",
+	}
+
+	for i, entry := range entries {
+		if entry.URL != expected[i] {
+			t.Errorf("Expected URL %s for %q, got %s", expected[i], entry.Word, entry.URL)
+		}
+	}
+}
+
+func TestWeiboLastUpdatedIn(t *testing.T) {
+	widget := &weiboWidget{Timezone: "Asia/Tokyo"}
+	if err := widget.initialize(); err != nil {
+		t.Fatalf("Failed to initialize weibo widget: %v", err)
+	}
+
+	if widget.LastUpdatedIn() != "" {
+		t.Errorf("Expected empty last updated time before the first update, got %q", widget.LastUpdatedIn())
+	}
+
+	widget.LastUpdated = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if actual := widget.LastUpdatedIn(); actual != "2024-01-01 09:00 JST" {
+		t.Errorf("Expected last updated time in Asia/Tokyo, got %q", actual)
+	}
+
+	if err := (&weiboWidget{Timezone: "Mars/Olympus"}).initialize(); err == nil {
+		t.Error("Expected an invalid timezone to be rejected")
+	}
+}
+
+func TestFormatRelativeTime(t *testing.T) {
+	now := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		elapsed  time.Duration
+		language string
+		expected string
+	}{
+		{30 * time.Second, "", "刚刚"},
+		{5 * time.Minute, "", "5分钟前"},
+		{3 * time.Hour, "zh", "3小时前"},
+		{30 * time.Second, "en", "just now"},
+		{time.Minute, "en", "1 minute ago"},
+		{5 * time.Minute, "en", "5 minutes ago"},
+		{49 * time.Hour, "en", "2 days ago"},
+		{-time.Minute, "en", "just now"},
+	}
+
+	for _, test := range tests {
+		if actual := formatRelativeTime(now.Add(-test.elapsed), now, test.language); actual != test.expected {
+			t.Errorf("Expected %q for %v in %q, got %q", test.expected, test.elapsed, test.language, actual)
+		}
+	}
+
+	if actual := formatRelativeTime(time.Time{}, now, "en"); actual != "" {
+		t.Errorf("Expected empty string for the zero time, got %q", actual)
+	}
+
+	if err := (&weiboWidget{RelativeLanguage: "fr"}).initialize(); err == nil {
+		t.Error("Expected an unsupported relative-language to be rejected")
+	}
+}
+
+func TestWeiboFetchHotSearchResponses(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        int
+		contentType   string
+		body          string
+		expectErr     bool
+		expectEntries int
+	}{
+		{"success", http.StatusOK, "application/json", `{"ok":1,"data":{"realtime":[{"word":"a","num":100},{"word":"b","num":90}]}}`, false, 2},
+		{"non-200", http.StatusServiceUnavailable, "text/plain", "unavailable", true, 0},
+		{"malformed JSON", http.StatusOK, "text/html", "<html>login</html>", true, 0},
+		{"error status", http.StatusOK, "application/json", `{"ok":0}`, true, 0},
+		{"empty data", http.StatusOK, "application/json", `{"ok":1,"data":{"realtime":[]}}`, false, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestStaticServer(t, test.status, test.contentType, test.body)
+
+			widget := &weiboWidget{APIURL: server.URL, Retries: -1}
+			if err := widget.initialize(); err != nil {
+				t.Fatalf("Failed to initialize weibo widget: %v", err)
+			}
+
+			entries, _, err := widget.fetchWeiboHotSearch(context.Background())
+			if test.expectErr != (err != nil) {
+				t.Fatalf("Expected error: %v, got %v", test.expectErr, err)
+			}
+
+			if len(entries) != test.expectEntries {
+				t.Errorf("Expected %d entries, got %d", test.expectEntries, len(entries))
+			}
+		})
+	}
+}
+
+// scriptedTransport replies to each request with the next response in order,
+// or with the error in its place to simulate network failures such as timeouts
+type scriptedTransport struct {
+	responses []scriptedResponse
+	requests  []*http.Request
+}
+
+type scriptedResponse struct {
+	status int
+	body   string
+	err    error
+}
+
+func (t *scriptedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests = append(t.requests, req)
+
+	next := t.responses[min(len(t.requests), len(t.responses))-1]
+	if next.err != nil {
+		return nil, next.err
+	}
+
+	return &http.Response{
+		StatusCode: next.status,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(next.body)),
+		Request:    req,
+	}, nil
+}
+
+func TestWeiboRetriesWithInjectedTransport(t *testing.T) {
+	transport := &scriptedTransport{responses: []scriptedResponse{
+		{status: http.StatusServiceUnavailable, body: "unavailable"},
+		{err: context.DeadlineExceeded},
+		{status: http.StatusOK, body: `{"ok":1,"data":{"realtime":[{"word":"a","num":100}]}}`},
+	}}
+
+	widget := &weiboWidget{Cookie: "SUB=secret", Headers: map[string]string{"referer": "https://s.weibo.com"}}
+	if err := widget.initialize(); err != nil {
+		t.Fatalf("Failed to initialize weibo widget: %v", err)
+	}
+	widget.setTransport(transport)
+
+	widget.update(context.Background())
+
+	if len(transport.requests) != 3 {
+		t.Fatalf("Expected 2 retries after a 503 and a timeout, got %d requests", len(transport.requests))
+	}
+
+	if widget.Error != nil || len(widget.HotSearches) != 1 {
+		t.Errorf("Expected the last attempt to succeed, got error %v and %d hot searches", widget.Error, len(widget.HotSearches))
+	}
+
+	for _, req := range transport.requests {
+		if req.Header.Get("Cookie") != "SUB=secret" || req.Header.Get("Referer") != "https://s.weibo.com" {
+			t.Errorf("Expected every attempt to send the configured headers, got %v", req.Header)
+		}
+	}
+
+	// 非重试状态码只请求一次
+	transport = &scriptedTransport{responses: []scriptedResponse{{status: http.StatusForbidden, body: "forbidden"}}}
+	widget.setTransport(transport)
+	widget.update(context.Background())
+
+	if len(transport.requests) != 1 || widget.Error == nil {
+		t.Errorf("Expected a single failed request for a 403 response, got %d requests and error %v", len(transport.requests), widget.Error)
+	}
+}
+
+func TestWeiboCleanWords(t *testing.T) {
+	tests := []struct {
+		word       string
+		stripEmoji bool
+		expected   string
+	}{
+		{"#春节档票房#", false, "春节档票房"},
+		{"  #春节档  票房#\t", false, "春节档 票房"},
+		{"春节档票房🔥", false, "春节档票房🔥"},
+		{"#春节档票房#🔥", true, "春节档票房"},
+		{"🇨🇳 中国队 夺冠 👍🏻", true, "中国队 夺冠"},
+		{"明天会更好☀️", true, "明天会更好"},
+		{"C#语言", true, "C#语言"},
+	}
+
+	for _, test := range tests {
+		if actual := cleanWeiboWord(test.word, test.stripEmoji); actual != test.expected {
+			t.Errorf("Expected %q to be cleaned to %q, got %q", test.word, test.expected, actual)
+		}
+	}
+
+	widget := newTestWeiboWidget(t)
+	widget.CleanWords = true
+	widget.StripEmoji = true
+
+	item := weiboHotSearchItem{Word: "#春节档票房#🔥"}
+	entries := widget.processHotSearches(newTestWeiboAPIResponse(item, weiboHotSearchItem{Word: "🔥🔥"}))
+
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(entries))
+	}
+
+	if entries[0].DisplayWord() != "春节档票房" {
+		t.Errorf("Expected the cleaned word to be displayed, got %q", entries[0].DisplayWord())
+	}
+
+	if entries[0].URL != safeURL("https://s.weibo.com/weibo?q="+url.QueryEscape(item.Word)) {
+		t.Errorf("Expected the search URL to use the original word, got %s", entries[0].URL)
+	}
+
+	if entries[1].DisplayWord() != "🔥🔥" {
+		t.Errorf("Expected a word that is cleaned to nothing to be displayed as is, got %q", entries[1].DisplayWord())
+	}
+}
+
+func TestWeiboHighlight(t *testing.T) {
+	widget := &weiboWidget{Highlight: []string{"iPhone", " 航天 ", ""}}
+	if err := widget.initialize(); err != nil {
+		t.Fatalf("Failed to initialize weibo widget: %v", err)
+	}
+
+	widget.HotSearches = widget.processHotSearches(newTestWeiboAPIResponse(
+		weiboHotSearchItem{Word: "IPHONE 新品发布", Num: 100},
+		weiboHotSearchItem{Word: "春节档票房", Num: 90},
+		weiboHotSearchItem{Word: "神舟航天员返回", Num: 80},
+	))
+
+	expected := []bool{true, false, true}
+	for i, entry := range widget.HotSearches {
+		if entry.Highlighted != expected[i] {
+			t.Errorf("Expected %q to have highlighted=%v", entry.Word, expected[i])
+		}
+	}
+
+	if html := string(widget.Render()); strings.Count(html, `gap-12 weibo-highlighted"`) != 2 {
+		t.Errorf("Expected the 2 matching entries to render with the highlight class, got: %s", html)
+	}
+}
+
+func TestWeiboRankDelta(t *testing.T) {
+	var round atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if round.Add(1) == 1 {
+			json.NewEncoder(w).Encode(newTestWeiboAPIResponse(
+				weiboHotSearchItem{Word: "a", Num: 300, RealPos: 1},
+				weiboHotSearchItem{Word: "b", Num: 200, RealPos: 2},
+				weiboHotSearchItem{Word: "c", Num: 100, RealPos: 3},
+			))
+			return
+		}
+		json.NewEncoder(w).Encode(newTestWeiboAPIResponse(
+			weiboHotSearchItem{Word: "b", Num: 300, RealPos: 1},
+			weiboHotSearchItem{Word: "a", Num: 200, RealPos: 2},
+			weiboHotSearchItem{Word: "c", Num: 100, RealPos: 3},
+			weiboHotSearchItem{Word: "d", Num: 50, RealPos: 4},
+		))
+	}))
+	defer server.Close()
+
+	widget := &weiboWidget{APIURL: server.URL, ShowRankChange: true}
+	if err := widget.initialize(); err != nil {
+		t.Fatalf("Failed to initialize weibo widget: %v", err)
+	}
+
+	widget.update(context.Background())
+	for _, entry := range widget.HotSearches {
+		if entry.RankDelta() != "" {
+			t.Errorf("Expected no rank change on the first update, got %q for %q", entry.RankDelta(), entry.Word)
+		}
+	}
+
+	widget.update(context.Background())
+	expected := map[string]string{
+		"b": weiboRankDeltaUp,
+		"a": weiboRankDeltaDown,
+		"c": weiboRankDeltaSame,
+		"d": weiboRankDeltaNew,
+	}
+
+	for _, entry := range widget.HotSearches {
+		if entry.RankDelta() != expected[entry.Word] {
+			t.Errorf("Expected %q to have rank change %q, got %q", entry.Word, expected[entry.Word], entry.RankDelta())
+		}
+	}
+
+	if widget.HotSearches[0].PreviousRank() != 2 {
+		t.Errorf("Expected the previous rank of b to be 2, got %d", widget.HotSearches[0].PreviousRank())
+	}
+
+	html := string(widget.Render())
+	if !strings.Contains(html, "▲") || !strings.Contains(html, "▼") || !strings.Contains(html, "NEW") {
+		t.Errorf("Expected rank change indicators to render, got: %s", html)
+	}
+}
+
+func TestWeiboUserAgent(t *testing.T) {
+	widget := newTestWeiboWidget(t)
+	if ua := widget.requestHeaders()["User-Agent"]; ua != weiboDefaultUserAgent {
+		t.Errorf("Expected the default user agent, got %q", ua)
+	}
+
+	widget.UserAgent = "custom-agent/1.0"
+	if ua := widget.requestHeaders()["User-Agent"]; ua != "custom-agent/1.0" {
+		t.Errorf("Expected user-agent to override the default, got %q", ua)
+	}
+
+	widget.Headers = map[string]string{"user-agent": "header-agent/2.0"}
+	if ua := widget.requestHeaders()["User-Agent"]; ua != "header-agent/2.0" {
+		t.Errorf("Expected headers to take precedence over user-agent, got %q", ua)
+	}
+}
+
+func TestWeiboMaxResponseBytesNotRetried(t *testing.T) {
+	transport := &scriptedTransport{responses: []scriptedResponse{
+		{status: http.StatusOK, body: `{"ok":1,"data":{"realtime":[{"word":"` + strings.Repeat("a", 1024) + `","num":100}]}}`},
+	}}
+
+	widget := &weiboWidget{MaxResponseBytes: 512}
+	if err := widget.initialize(); err != nil {
+		t.Fatalf("Failed to initialize weibo widget: %v", err)
+	}
+	widget.setTransport(transport)
+
+	if _, _, err := widget.fetchWeiboHotSearch(context.Background()); !errors.Is(err, errResponseTooLarge) {
+		t.Fatalf("Expected an oversized response to fail with errResponseTooLarge, got %v", err)
+	}
+
+	if len(transport.requests) != 1 {
+		t.Errorf("Expected an oversized response not to be retried, got %d requests", len(transport.requests))
+	}
+}
+
+func TestWeiboJSONThroughRouter(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(newTestWeiboAPIResponse(
+			weiboHotSearchItem{Word: "a", Num: 300, RealPos: 1},
+			weiboHotSearchItem{Word: "b", Num: 200, RealPos: 2},
+		))
+	}))
+	defer api.Close()
+
+	config, err := newConfigFromYAML([]byte(`
+pages:
+  - name: Home
+    columns:
+      - size: full
+        widgets:
+          - type: weibo
+            apiurl: ` + api.URL + `
+            static-items:
+              - word: 置顶话题
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	app, err := newApplication(config)
+	if err != nil {
+		t.Fatalf("Failed to create application: %v", err)
+	}
+
+	var widgetID uint64
+	for id := range app.widgetByID {
+		widgetID = id
+	}
+
+	server := httptest.NewServer(app.newServeMux())
+	defer server.Close()
+
+	// 页面渲染时进行首次更新
+	response, err := http.Get(server.URL + "/api/pages/home/content/")
+	if err != nil {
+		t.Fatalf("Failed to request page content: %v", err)
+	}
+	response.Body.Close()
+
+	response, err = http.Get(server.URL + "/api/widgets/" + strconv.FormatUint(widgetID, 10) + "/?format=json")
+	if err != nil {
+		t.Fatalf("Failed to request widget JSON: %v", err)
+	}
+	defer response.Body.Close()
+
+	var body struct {
+		OK          bool                 `json:"ok"`
+		HotSearches []weiboHotSearchJSON `json:"hot_searches"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode widget JSON: %v", err)
+	}
+
+	ranks := make(map[string]int)
+	for _, hotSearch := range body.HotSearches {
+		ranks[hotSearch.Word] = hotSearch.Rank
+	}
+
+	// 置顶条目不占用实时热搜的排名
+	if !body.OK || ranks["a"] != 1 || ranks["b"] != 2 {
+		t.Errorf("Expected hot searches to keep their real positions, got %+v", body.HotSearches)
+	}
+}
+
+func TestHotSearchCategoryFilter(t *testing.T) {
+	items := []hotSearchItem{
+		{Title: "a", Category: "娱"},
+		{Title: "b", Category: "体"},
+		{Title: "c", noCategories: true},
+		{Title: "d"},
+	}
+
+	for _, category := range []string{"娱乐", "娱", " 娱乐 "} {
+		base := hotSearchWidgetBase{Category: category}
+		if err := base.initializeHotSearch("Hot Search", slog.Default()); err != nil {
+			t.Fatalf("Failed to initialize hot search base: %v", err)
+		}
+
+		var titles []string
+		for _, item := range applyHotSearchFilters(&base, items) {
+			titles = append(titles, item.Title)
+		}
+		if !slices.Equal(titles, []string{"a", "c"}) {
+			t.Errorf("category %q: expected [a c], got %v", category, titles)
+		}
+	}
+
+	zhihu := &zhihuWidget{hotSearchWidgetBase: hotSearchWidgetBase{Category: "娱乐"}}
+	if err := zhihu.initialize(); err == nil {
+		t.Error("expected zhihu widget with a category to fail to initialize")
+	}
+}
+
+func TestWeiboRankRenderWithoutRealPos(t *testing.T) {
+	widget := newTestWeiboWidget(t)
+	widget.HotSearches = []weiboHotSearchEntry{
+		{weiboHotSearchItem: weiboHotSearchItem{Word: "gov"}, Position: 3},
+	}
+
+	for _, padRank := range []bool{false, true} {
+		widget.PadRank = padRank
+		html := string(widget.Render())
+		start := strings.Index(html, "weibo-rank ")
+		if start == -1 {
+			t.Fatalf("Expected rank markup, got: %s", html)
+		}
+		rank := html[start:]
+		rank = strings.TrimSpace(rank[strings.Index(rank, ">")+1 : strings.Index(rank, "</div>")])
+		if rank != "3" {
+			t.Errorf("Expected rank 3 with pad-rank %v, got %q", padRank, rank)
+		}
+	}
+}
+
+func TestSharedHTTPClientReusesDefaultTransport(t *testing.T) {
+	if sharedHTTPClient.Transport != defaultHTTPClient.Transport {
+		t.Error("Expected the shared and default HTTP clients to use the same transport")
+	}
+}
+
+func TestHotSearchCircuitBreaker(t *testing.T) {
+	unavailable := []scriptedResponse{{status: http.StatusServiceUnavailable, body: "unavailable"}}
+
+	zhihu := &zhihuWidget{}
+	if err := zhihu.initialize(); err != nil {
+		t.Fatalf("Failed to initialize zhihu widget: %v", err)
+	}
+	zhihuTransport := &scriptedTransport{responses: unavailable}
+	zhihu.setTransport(zhihuTransport)
+
+	trending := &trendingWidget{
+		hotSearchWidgetBase: hotSearchWidgetBase{Timeout: durationField(7 * time.Second)},
+		Sources:             []string{"zhihu"},
+	}
+	if err := trending.initialize(); err != nil {
+		t.Fatalf("Failed to initialize trending widget: %v", err)
+	}
+	source := trending.sources[0].(*zhihuWidget)
+	if source.Timeout != trending.Timeout {
+		t.Errorf("Expected trending sources to use the trending timeout, got %s", time.Duration(source.Timeout))
+	}
+	trendingTransport := &scriptedTransport{responses: unavailable}
+	source.setTransport(trendingTransport)
+
+	tests := []struct {
+		name      string
+		update    func(context.Context)
+		base      *hotSearchWidgetBase
+		transport *scriptedTransport
+	}{
+		{"zhihu", zhihu.update, &zhihu.hotSearchWidgetBase, zhihuTransport},
+		{"trending", trending.update, &trending.hotSearchWidgetBase, trendingTransport},
+	}
+
+	for _, test := range tests {
+		for range circuitBreakerThreshold + 1 {
+			test.update(context.Background())
+		}
+
+		if requests := len(test.transport.requests); requests != circuitBreakerThreshold {
+			t.Errorf("%s: expected no requests while the breaker is open, got %d requests", test.name, requests)
+		}
+
+		if until := time.Until(test.base.nextUpdate); until < circuitBreakerBaseDelay-time.Minute {
+			t.Errorf("%s: expected next update to be delayed by the breaker, got %s", test.name, until)
+		}
+	}
+}
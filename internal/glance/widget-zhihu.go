@@ -0,0 +1,132 @@
+package glance
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var zhihuWidgetTemplate = mustParseTemplate("zhihu.html", "widget-base.html")
+
+// 知乎热榜接口地址
+const zhihuHotListURL = "https://www.zhihu.com/api/v3/feed/topstory/hot-lists/total?limit=50"
+
+// 知乎热榜Widget
+type zhihuWidget struct {
+	hotSearchWidgetBase `yaml:",inline"`
+
+	// 内部数据
+	HotItems    []hotSearchItem `yaml:"-"`
+	LastUpdated time.Time       `yaml:"-"`
+}
+
+// 知乎热榜接口响应结构
+type zhihuHotListResponse struct {
+	Data []struct {
+		DetailText string `json:"detail_text"`
+		Target     struct {
+			ID    int64  `json:"id"`
+			Title string `json:"title"`
+		} `json:"target"`
+	} `json:"data"`
+}
+
+func (widget *zhihuWidget) initialize() error {
+	// 知乎热榜的条目没有类别，设置 category 只会得到空列表
+	if widget.Category != "" {
+		return fmt.Errorf("category is not supported, zhihu hot list items have no category")
+	}
+
+	widget.withTitleURL("https://www.zhihu.com/hot")
+	if err := widget.initializeHotSearch("Zhihu HotList", widget.logger()); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (widget *zhihuWidget) update(ctx context.Context) {
+	// 接口持续失败时在退避期间内不再请求
+	if widget.breaker.isOpen() {
+		return
+	}
+
+	items, err := widget.fetchZhihuHotList(ctx)
+	if !widget.handleUpdateResult(err, widget.logger(), "Failed to fetch zhihu hot list") {
+		return
+	}
+
+	widget.HotItems = truncateHotSearches(&widget.hotSearchWidgetBase, applyHotSearchFilters(&widget.hotSearchWidgetBase, items))
+	widget.LastUpdated = time.Now()
+}
+
+// 请求知乎热榜接口并转换为通用的热榜条目
+func (widget *zhihuWidget) fetchZhihuHotList(ctx context.Context) ([]hotSearchItem, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(widget.Timeout))
+	defer cancel()
+
+	headers := map[string]string{
+		"User-Agent": getBrowserUserAgentHeader(),
+		"Accept":     "application/json, text/plain, */*",
+		"Referer":    "https://www.zhihu.com/hot",
+	}
+
+	var response zhihuHotListResponse
+	err := fetchJSON(ctx, widget.httpClient(), "GET", zhihuHotListURL, headers, nil, &response)
+	widget.stats.recordFetch(err)
+	if err != nil {
+		return nil, fmt.Errorf("获取知乎热榜失败: %w", err)
+	}
+
+	items := make([]hotSearchItem, 0, len(response.Data))
+	for i, entry := range response.Data {
+		items = append(items, hotSearchItem{
+			Source:   "zhihu",
+			Rank:     i + 1,
+			Title:    strings.TrimSpace(entry.Target.Title),
+			Heat:     parseZhihuHeat(entry.DetailText),
+			HeatText: strings.TrimSpace(entry.DetailText),
+			URL:      safeURL(fmt.Sprintf("https://www.zhihu.com/question/%d", entry.Target.ID)),
+
+			noCategories: true,
+		})
+	}
+
+	return items, nil
+}
+
+func (widget *zhihuWidget) fetchHotSearchItems(ctx context.Context) ([]hotSearchItem, error) {
+	return widget.fetchZhihuHotList(ctx)
+}
+
+// 将“1234 万热度”形式的热度文本转换为数值，无法解析时返回0
+func parseZhihuHeat(text string) int64 {
+	text = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(text), "热度"))
+
+	multiplier := 1.0
+	if number, found := strings.CutSuffix(text, "万"); found {
+		text, multiplier = number, 1e4
+	} else if number, found := strings.CutSuffix(text, "亿"); found {
+		text, multiplier = number, 1e8
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+	if err != nil {
+		return 0
+	}
+
+	return int64(value * multiplier)
+}
+
+// 带有Widget类型和ID的日志记录器
+func (widget *zhihuWidget) logger() *slog.Logger {
+	return slog.With("widget", "zhihu", "widget_id", widget.ID)
+}
+
+func (widget *zhihuWidget) Render() template.HTML {
+	return widget.renderTemplate(widget, zhihuWidgetTemplate)
+}
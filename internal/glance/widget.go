@@ -9,6 +9,7 @@ import (
 	"log/slog"
 	"math"
 	"net/http"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -83,6 +84,10 @@ func newWidget(widgetType string) (widget, error) {
 		w = &todoWidget{}
 	case "weibo":
 		w = &weiboWidget{}
+	case "zhihu":
+		w = &zhihuWidget{}
+	case "trending":
+		w = &trendingWidget{}
 	case "random-fact":
 		w = &randomFactWidget{}
 	default:
@@ -168,10 +173,45 @@ type widgetBase struct {
 	cacheType           cacheType        `yaml:"-"`
 	nextUpdate          time.Time        `yaml:"-"`
 	updateRetriedTimes  int              `yaml:"-"`
+	transportClient     *http.Client     `yaml:"-"`
 }
 
 type widgetProviders struct {
 	assetResolver func(string) string
+	httpClient    *http.Client
+	fileReloaders *fileReloaders
+}
+
+// fileReloaders holds callbacks registered by widgets that read files on
+// initialization, allowing those files to be re-read without a restart
+type fileReloaders struct {
+	mu        sync.Mutex
+	reloaders []func() error
+}
+
+func (r *fileReloaders) register(reload func() error) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reloaders = append(r.reloaders, reload)
+}
+
+func (r *fileReloaders) reload() []error {
+	r.mu.Lock()
+	reloaders := r.reloaders
+	r.mu.Unlock()
+
+	var errs []error
+	for _, reload := range reloaders {
+		if err := reload(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errs
 }
 
 func (w *widgetBase) requiresUpdate(now *time.Time) bool {
@@ -218,6 +258,28 @@ func (w *widgetBase) setProviders(providers *widgetProviders) {
 	w.Providers = providers
 }
 
+// setTransport makes the widget send its requests through the given
+// transport instead of the shared client, which allows tests to simulate
+// timeouts and specific responses without a live server
+func (w *widgetBase) setTransport(transport http.RoundTripper) {
+	w.transportClient = &http.Client{Transport: transport}
+}
+
+// httpClient returns the client using the transport given to setTransport if
+// any, otherwise the client shared between widgets, falling back to
+// sharedHTTPClient when the widget hasn't been given providers yet
+func (w *widgetBase) httpClient() *http.Client {
+	if w.transportClient != nil {
+		return w.transportClient
+	}
+
+	if w.Providers != nil && w.Providers.httpClient != nil {
+		return w.Providers.httpClient
+	}
+
+	return sharedHTTPClient
+}
+
 func (w *widgetBase) renderTemplate(data any, t *template.Template) template.HTML {
 	w.templateBuffer.Reset()
 	err := t.Execute(&w.templateBuffer, data)